@@ -0,0 +1,38 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AccessProfileSpec defines a named set of roles that policies grant together. Roles scoped to
+// a namespace are only bound in that namespace, the others in every namespace access is granted to.
+type AccessProfileSpec struct {
+	Roles []RoleRefWithNamespace `json:"roles"` // Roles and ClusterRoles granted by the profile
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+type AccessProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AccessProfileSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type AccessProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AccessProfile `json:"items"`
+}
+
+func (in *AccessProfileSpec) DeepCopyInto(out *AccessProfileSpec) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]RoleRefWithNamespace, len(*in))
+		copy(*out, *in)
+	}
+}
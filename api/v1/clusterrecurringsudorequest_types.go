@@ -0,0 +1,24 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+type ClusterRecurringSudoRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RecurringSudoRequestSpec   `json:"spec,omitempty"`
+	Status RecurringSudoRequestStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type ClusterRecurringSudoRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterRecurringSudoRequest `json:"items"`
+}
@@ -25,8 +25,8 @@ type ClusterTemporaryRBACList struct {
 func (in *ClusterTemporaryRBAC) DeepCopyInto(out *ClusterTemporaryRBAC) {
     *out = *in
     in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-    out.Spec = in.Spec // Assuming Spec does not require DeepCopy
-    out.Status = in.Status // Assuming Status does not require DeepCopy
+    in.Spec.DeepCopyInto(&out.Spec)
+    in.Status.DeepCopyInto(&out.Status)
 }
 
 func (in *ClusterTemporaryRBAC) DeepCopy() *ClusterTemporaryRBAC {
@@ -31,6 +31,14 @@ func addKnownTypes(scheme *runtime.Scheme) error {
         &SudoPolicyList{},
         &ClusterSudoPolicy{},
         &ClusterSudoPolicyList{},
+        &RecurringSudoRequest{},
+        &RecurringSudoRequestList{},
+        &ClusterRecurringSudoRequest{},
+        &ClusterRecurringSudoRequestList{},
+        &Lockdown{},
+        &LockdownList{},
+        &AccessProfile{},
+        &AccessProfileList{},
 	)
 	// Add the common metadata type
 	metav1.AddToGroupVersion(scheme, GroupVersion)
@@ -0,0 +1,52 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LockdownSpec defines an emergency lockdown of elevated access. Each scope narrows down the lockdown,
+// a lockdown without any scope covers the whole cluster.
+type LockdownSpec struct {
+	Reason     string   `json:"reason"`               // Why access is locked down, included in every event
+	Namespaces []string `json:"namespaces,omitempty"` // Namespaces covered by the lockdown, all if empty, may contain glob wildcards
+	Policies   []string `json:"policies,omitempty"`   // Names of the policies covered by the lockdown, all if empty, may contain glob wildcards
+	PolicyKind string   `json:"policyKind,omitempty"` // Kind of the policies, SudoPolicy or ClusterSudoPolicy, required with policies
+	Users      []string `json:"users,omitempty"`      // Users covered by the lockdown, all if empty, may contain glob wildcards
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+type Lockdown struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LockdownSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type LockdownList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Lockdown `json:"items"`
+}
+
+func (in *LockdownSpec) DeepCopyInto(out *LockdownSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Users != nil {
+		in, out := &in.Users, &out.Users
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
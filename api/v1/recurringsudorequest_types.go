@@ -0,0 +1,96 @@
+package v1
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RecurringSudoRequestSpec defines a grant that recurs on a cron schedule
+type RecurringSudoRequestSpec struct {
+	Schedule      string         `json:"schedule"`                // Cron expression of when occurrences start, e.g. "0 18 * * 5"
+	TimeZone      string         `json:"timeZone,omitempty"`      // Time zone the schedule is evaluated in, defaults to UTC
+	Duration      string         `json:"duration"`                // Duration of every occurrence, e.g. "48h"
+	Policy        string         `json:"policy"`                  // Name of the policy every occurrence is validated against
+	Subject       rbacv1.Subject `json:"subject"`                 // Subject granted access by every occurrence
+	Suspend       bool           `json:"suspend,omitempty"`       // Stops new occurrences, running ones expire as scheduled
+	HistoryLimit  *int32         `json:"historyLimit,omitempty"`  // Number of finished occurrences to keep, defaults to 3
+	Justification string         `json:"justification,omitempty"` // Why the access is needed, carried to every occurrence
+	Ticket        string         `json:"ticket,omitempty"`        // Reference of the ticket the access is needed for, carried to every occurrence
+	Creator       UserIdentity   `json:"creator,omitempty"`       // Identity of the creator, set by the webhook and immutable
+}
+
+// Occurrence records one scheduled run of a recurring request
+type Occurrence struct {
+	ScheduledTime metav1.Time     `json:"scheduledTime"`           // When the occurrence was due
+	State         string          `json:"state"`                   // Granted or Skipped
+	Message       string          `json:"message,omitempty"`       // Why the occurrence was skipped
+	ChildResource []ChildResource `json:"childResource,omitempty"` // Resources created for the occurrence
+}
+
+// RecurringSudoRequestStatus defines the observed state of RecurringSudoRequest
+type RecurringSudoRequestStatus struct {
+	State            string       `json:"state,omitempty"` // Current state: Active, Suspended, Error
+	RequestID        string       `json:"requestID,omitempty"`
+	ErrorMessage     string       `json:"errorMessage,omitempty"`
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"` // When the last occurrence was due
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"` // When the next occurrence is due
+	Occurrences      []Occurrence `json:"occurrences,omitempty"`      // Most recent occurrences, oldest first
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+type RecurringSudoRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RecurringSudoRequestSpec   `json:"spec,omitempty"`
+	Status RecurringSudoRequestStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type RecurringSudoRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RecurringSudoRequest `json:"items"`
+}
+
+func (in *RecurringSudoRequestSpec) DeepCopyInto(out *RecurringSudoRequestSpec) {
+	*out = *in
+	if in.HistoryLimit != nil {
+		in, out := &in.HistoryLimit, &out.HistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	in.Creator.DeepCopyInto(&out.Creator)
+}
+
+func (in *Occurrence) DeepCopyInto(out *Occurrence) {
+	*out = *in
+	in.ScheduledTime.DeepCopyInto(&out.ScheduledTime)
+	if in.ChildResource != nil {
+		in, out := &in.ChildResource, &out.ChildResource
+		*out = make([]ChildResource, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *RecurringSudoRequestStatus) DeepCopyInto(out *RecurringSudoRequestStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextScheduleTime != nil {
+		in, out := &in.NextScheduleTime, &out.NextScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Occurrences != nil {
+		in, out := &in.Occurrences, &out.Occurrences
+		*out = make([]Occurrence, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
@@ -16,17 +16,114 @@ import (
 
 // SudoPolicySpec defines the desired state of SudoPolicy
 type SudoPolicySpec struct {
-	MaxDuration               string                `json:"maxDuration"`                         // Maximum allowed duration
-	RoleRef                   rbacv1.RoleRef        `json:"roleRef"`                             // Role or ClusterRole reference
-	AllowedUsers              []UserRef             `json:"allowedUsers"`                        // List of allowed users
-	AllowedNamespaces         []string              `json:"allowedNamespaces,omitempty"`         // Specific namespaces
-	AllowedNamespacesSelector *metav1.LabelSelector `json:"allowedNamespacesSelector,omitempty"` // Namespace selector
+	MaxDuration               string                 `json:"maxDuration"`                         // Maximum allowed duration
+	MaxTotalDuration          string                 `json:"maxTotalDuration,omitempty"`          // Maximum lifetime of a request including its extensions
+	RoleRef                   rbacv1.RoleRef         `json:"roleRef,omitempty"`                   // Role or ClusterRole reference, unset when an access profile is granted
+	AccessProfile             string                 `json:"accessProfile,omitempty"`             // Name of the AccessProfile whose roles are granted instead of a single role
+	Rules                     []rbacv1.PolicyRule    `json:"rules,omitempty"`                     // Inline rules of an ephemeral Role or ClusterRole created for every grant
+	AllowedUsers              []UserRef              `json:"allowedUsers,omitempty"`              // List of allowed users, names may contain glob wildcards
+	AllowedGroups             []string               `json:"allowedGroups,omitempty"`             // List of allowed groups, names may contain '*' and '?' glob wildcards, not regular expressions
+	AllowedNamespaces         []string               `json:"allowedNamespaces,omitempty"`         // Specific namespaces
+	AllowedNamespacesSelector *metav1.LabelSelector  `json:"allowedNamespacesSelector,omitempty"` // Namespace selector
+	AllowedResourceNames      []string               `json:"allowedResourceNames,omitempty"`      // Glob patterns of the objects, <resource>/<name>, requests may restrict their grant to
+	ApprovalMode              string                 `json:"approvalMode,omitempty"`              // Automatic (default) or Manual
+	Approvers                 []ApproverRef          `json:"approvers,omitempty"`                 // Users and groups allowed to approve requests
+	RequiredApprovals         int                    `json:"requiredApprovals,omitempty"`         // Number of distinct approvers needed, defaults to 1
+	PendingTTL                string                 `json:"pendingTTL,omitempty"`                // How long a request may wait for approval before it is rejected
+	Escalations               []EscalationTier       `json:"escalations,omitempty"`               // Additional approvers that become eligible over time
+	RequiredAttributes        []AttributeRequirement `json:"requiredAttributes,omitempty"`        // Conditions on the requester's extra attributes
+	Conditions                []PolicyCondition      `json:"conditions,omitempty"`                // CEL expressions that must all evaluate to true
+	Schedule                  *AccessSchedule        `json:"schedule,omitempty"`                  // Time windows and blackout periods for requests
+	BreakGlass                *BreakGlassPolicy      `json:"breakGlass,omitempty"`                // Allows emergency requests that are reviewed after the fact
+	Justification             *TextRequirement       `json:"justification,omitempty"`             // Requirements on the justification of requests
+	Ticket                    *TextRequirement       `json:"ticket,omitempty"`                    // Requirements on the ticket reference of requests
+	Quotas                    []RequestQuota         `json:"quotas,omitempty"`                    // Limits on the requests of a single user over time
+	Cooldown                  string                 `json:"cooldown,omitempty"`                  // Time a user must wait after a grant ends before the next one
+	MaxActive                 int                    `json:"maxActive,omitempty"`                 // Number of grants that may be active under the policy at once
+	MaxActivePerUser          int                    `json:"maxActivePerUser,omitempty"`          // Number of grants a single user may hold under the policy at once
+	OnDuplicate               string                 `json:"onDuplicate,omitempty"`               // Allow (default), Reject or Merge requests duplicating an active grant
+	OnLimitReached            string                 `json:"onLimitReached,omitempty"`            // Reject (default) or Queue requests over the concurrency limits
 }
 
 // UserRef defines a reference to a user
 // allowed to request sudo access
 type UserRef struct {
-	Name string `json:"name"` // Name of the allowed user
+	Name string `json:"name"` // Name of the allowed user, '*' and '?' act as glob wildcards, regular expressions are not supported
+}
+
+// ApproverRef defines a user or group
+// allowed to approve sudo requests
+type ApproverRef struct {
+	Kind string `json:"kind"` // User or Group
+	Name string `json:"name"` // Name of the user or group
+}
+
+// EscalationTier defines approvers that become eligible
+// once a request has been pending for the given duration
+type EscalationTier struct {
+	After     string        `json:"after"`     // Time since submission after which the tier becomes eligible
+	Approvers []ApproverRef `json:"approvers"` // Users and groups of this tier
+}
+
+// BreakGlassPolicy allows emergency requests that bypass manual
+// approval and the schedule, and are reviewed after the fact
+type BreakGlassPolicy struct {
+	Reviewers     []ApproverRef `json:"reviewers,omitempty"`     // Users and groups allowed to review break-glass requests, defaults to the approvers
+	MaxUnreviewed int           `json:"maxUnreviewed,omitempty"` // Unreviewed break-glass requests a user may have before further ones are rejected, defaults to 1
+}
+
+// TextRequirement defines whether a free-text field
+// of a request is mandatory and the pattern it must match
+type TextRequirement struct {
+	Required bool   `json:"required,omitempty"` // Rejects requests that leave the field empty
+	Pattern  string `json:"pattern,omitempty"`  // Regular expression the value must match, e.g. ^OPS-[0-9]+$
+}
+
+// RequestQuota limits the requests granted to a single
+// user under a policy within a sliding time window
+type RequestQuota struct {
+	Window                string `json:"window"`                          // Sliding window the limits apply to, e.g. "24h"
+	MaxRequests           int    `json:"maxRequests,omitempty"`           // Number of requests that may be granted within the window
+	MaxCumulativeDuration string `json:"maxCumulativeDuration,omitempty"` // Total duration of access that may be granted within the window
+}
+
+// AttributeRequirement defines a condition on an extra
+// attribute of the requester, such as an OIDC claim
+type AttributeRequirement struct {
+	Key      string   `json:"key"`              // Name of the extra attribute
+	Operator string   `json:"operator"`         // Equals, In or Exists
+	Values   []string `json:"values,omitempty"` // A single value for Equals, the accepted values for In
+}
+
+// PolicyCondition defines a CEL expression evaluated against
+// the request, the requester identity, the target namespaces and the current time
+type PolicyCondition struct {
+	Name       string `json:"name"`              // Name of the condition, used in messages
+	Expression string `json:"expression"`        // CEL expression evaluating to a bool
+	Message    string `json:"message,omitempty"` // Message reported when the condition is not satisfied
+}
+
+// AccessSchedule defines when requests are allowed
+type AccessSchedule struct {
+	TimeZone         string           `json:"timeZone,omitempty"`         // IANA time zone of the windows, defaults to UTC
+	Windows          []TimeWindow     `json:"windows,omitempty"`          // Requests are only allowed inside these windows, if any
+	Blackouts        []BlackoutPeriod `json:"blackouts,omitempty"`        // Periods during which requests are rejected
+	EnforceWindowEnd bool             `json:"enforceWindowEnd,omitempty"` // Cut grants short when the window closes or a blackout starts
+}
+
+// TimeWindow defines a recurring daily time window
+type TimeWindow struct {
+	Days  []string `json:"days,omitempty"` // Days the window opens on (e.g. Mon, Tue), every day if empty
+	Start string   `json:"start"`          // Opening time, HH:MM
+	End   string   `json:"end"`            // Closing time, HH:MM, the window spans midnight if before start
+}
+
+// BlackoutPeriod defines a period, such as a change freeze,
+// during which requests are rejected
+type BlackoutPeriod struct {
+	Name  string      `json:"name"`  // Name of the blackout, used in messages
+	Start metav1.Time `json:"start"` // When the blackout starts
+	End   metav1.Time `json:"end"`   // When the blackout ends
 }
 
 // SudoPolicyStatus defines the observed state of SudoPolicy
@@ -97,11 +194,23 @@ type SudoPolicyList struct {
 func (in *SudoPolicySpec) DeepCopyInto(out *SudoPolicySpec) {
 	*out = *in
 	out.RoleRef = in.RoleRef // rbacv1.RoleRef is already a simple struct; no deep copy needed.
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.AllowedUsers != nil {
 		in, out := &in.AllowedUsers, &out.AllowedUsers
 		*out = make([]UserRef, len(*in))
 		copy(*out, *in)
 	}
+	if in.AllowedGroups != nil {
+		in, out := &in.AllowedGroups, &out.AllowedGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.AllowedNamespaces != nil {
 		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
 		*out = make([]string, len(*in))
@@ -111,4 +220,118 @@ func (in *SudoPolicySpec) DeepCopyInto(out *SudoPolicySpec) {
 		in, out := &in.AllowedNamespacesSelector, &out.AllowedNamespacesSelector
 		*out = (*in).DeepCopy()
 	}
+	if in.AllowedResourceNames != nil {
+		in, out := &in.AllowedResourceNames, &out.AllowedResourceNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Approvers != nil {
+		in, out := &in.Approvers, &out.Approvers
+		*out = make([]ApproverRef, len(*in))
+		copy(*out, *in)
+	}
+	if in.Escalations != nil {
+		in, out := &in.Escalations, &out.Escalations
+		*out = make([]EscalationTier, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RequiredAttributes != nil {
+		in, out := &in.RequiredAttributes, &out.RequiredAttributes
+		*out = make([]AttributeRequirement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]PolicyCondition, len(*in))
+		copy(*out, *in)
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(AccessSchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BreakGlass != nil {
+		in, out := &in.BreakGlass, &out.BreakGlass
+		*out = new(BreakGlassPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Justification != nil {
+		in, out := &in.Justification, &out.Justification
+		*out = new(TextRequirement)
+		**out = **in
+	}
+	if in.Ticket != nil {
+		in, out := &in.Ticket, &out.Ticket
+		*out = new(TextRequirement)
+		**out = **in
+	}
+	if in.Quotas != nil {
+		in, out := &in.Quotas, &out.Quotas
+		*out = make([]RequestQuota, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *BreakGlassPolicy) DeepCopyInto(out *BreakGlassPolicy) {
+	*out = *in
+	if in.Reviewers != nil {
+		in, out := &in.Reviewers, &out.Reviewers
+		*out = make([]ApproverRef, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *AccessSchedule) DeepCopyInto(out *AccessSchedule) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]TimeWindow, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Blackouts != nil {
+		in, out := &in.Blackouts, &out.Blackouts
+		*out = make([]BlackoutPeriod, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+func (in *TimeWindow) DeepCopyInto(out *TimeWindow) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *BlackoutPeriod) DeepCopyInto(out *BlackoutPeriod) {
+	*out = *in
+	in.Start.DeepCopyInto(&out.Start)
+	in.End.DeepCopyInto(&out.End)
+}
+
+func (in *AttributeRequirement) DeepCopyInto(out *AttributeRequirement) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *EscalationTier) DeepCopyInto(out *EscalationTier) {
+	*out = *in
+	if in.Approvers != nil {
+		in, out := &in.Approvers, &out.Approvers
+		*out = make([]ApproverRef, len(*in))
+		copy(*out, *in)
+	}
 }
@@ -1,24 +1,95 @@
 package v1
 
 import (
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-//     "k8s.io/apimachinery/pkg/runtime"
+	// "k8s.io/apimachinery/pkg/runtime"
 )
 
 // SudoRequestSpec defines the desired state of SudoRequest
 type SudoRequestSpec struct {
-	Duration string `json:"duration"` // e.g., "1h" for one hour
-	Policy   string `json:"policy"`   // Name of the SudoPolicy to enforce
+	Duration      string              `json:"duration"`                // e.g., "1h" for one hour
+	Policy        string              `json:"policy,omitempty"`        // Name of the SudoPolicy to enforce, selected from RoleRef when unset
+	Policies      []string            `json:"policies,omitempty"`      // Policies granted together as a bundle, instead of Policy
+	RoleRef       *rbacv1.RoleRef     `json:"roleRef,omitempty"`       // Role requested instead of a policy, the policy is selected by the controller
+	Namespaces    []string            `json:"namespaces,omitempty"`    // Namespaces requested by a ClusterSudoRequest, all namespaces of the policy if unset
+	Approvals     []Approval          `json:"approvals,omitempty"`     // Sign-offs recorded by the webhook, user supplied values are discarded
+	Requester     UserIdentity        `json:"requester,omitempty"`     // Identity of the requester, set by the webhook and immutable
+	StartTime     *metav1.Time        `json:"startTime,omitempty"`     // When the access should be activated, immediately if unset
+	Extensions    []ExtensionRequest  `json:"extensions,omitempty"`    // Extensions recorded by the webhook, user supplied values are discarded
+	Revocation    *Revocation         `json:"revocation,omitempty"`    // Revocation recorded by the webhook, user supplied values are discarded
+	BreakGlass    bool                `json:"breakGlass,omitempty"`    // Emergency request bypassing manual approval and the schedule, reviewed after the fact
+	Justification string              `json:"justification,omitempty"` // Why the access is needed, required for break-glass requests
+	Ticket        string              `json:"ticket,omitempty"`        // Reference of the ticket the access is needed for, e.g. OPS-1234
+	Reviews       []Review            `json:"reviews,omitempty"`       // Reviews recorded by the webhook, user supplied values are discarded
+	Rules         []rbacv1.PolicyRule `json:"rules,omitempty"`         // Subset of the permissions of the policy requested, all of them if unset
+	ResourceNames []string            `json:"resourceNames,omitempty"` // Objects the grant is restricted to, <resource>/<name>, e.g. secrets/db-credentials
+}
+
+// UserIdentity is the authenticated identity
+// of a user as seen by the admission webhook
+type UserIdentity struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// Approval records a sign-off on a request
+type Approval struct {
+	Approver  UserIdentity `json:"approver"`  // Who approved the request
+	Timestamp metav1.Time  `json:"timestamp"` // When the request was approved
+}
+
+// ExtensionRequest asks for an approved request to be extended
+type ExtensionRequest struct {
+	Duration  string      `json:"duration"`  // Additional duration requested, e.g. "30m"
+	Timestamp metav1.Time `json:"timestamp"` // When the extension was requested
+}
+
+// ExtensionRecord records the outcome of an extension request
+type ExtensionRecord struct {
+	Duration   string       `json:"duration"`             // Additional duration requested
+	State      string       `json:"state"`                // Granted or Rejected
+	Message    string       `json:"message,omitempty"`    // Why the extension was rejected
+	Timestamp  metav1.Time  `json:"timestamp"`            // When the extension was granted or rejected
+	ExpiresAt  *metav1.Time `json:"expiresAt,omitempty"`  // Expiration of the request after the extension
+	MergedFrom string       `json:"mergedFrom,omitempty"` // Duplicate request merged into this one, set when the extension was not requested by annotation
+}
+
+// Revocation records who ended a request early, when and why
+type Revocation struct {
+	RevokedBy UserIdentity `json:"revokedBy"`          // Who revoked the request
+	Lockdown  string       `json:"lockdown,omitempty"` // Lockdown that revoked the request, if any
+	Reason    string       `json:"reason,omitempty"`   // Why the request was revoked
+	Timestamp metav1.Time  `json:"timestamp"`          // When the request was revoked
+}
+
+// Review records the acknowledgement of a break-glass request after the fact
+type Review struct {
+	Reviewer  UserIdentity `json:"reviewer"`          // Who reviewed the request
+	Comment   string       `json:"comment,omitempty"` // Outcome of the review
+	Timestamp metav1.Time  `json:"timestamp"`         // When the request was reviewed
 }
 
 // SudoRequestStatus defines the observed state of SudoRequest
 type SudoRequestStatus struct {
-	State     string       `json:"state,omitempty"`     // Current state: Pending, Approved, Expired
-	RequestID     string       `json:"requestID,omitempty"`
-	ErrorMessage     string       `json:"errorMessage,omitempty"`
-	CreatedAt *metav1.Time `json:"createdAt,omitempty"` // Timestamp when the request was created
-	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"` // Timestamp when the request will expire
-	ChildResource []ChildResource `json:"childResource,omitempty"` // Details of the associated resource
+	State           string            `json:"state,omitempty"` // Current state: Pending, Approved, Expired
+	RequestID       string            `json:"requestID,omitempty"`
+	ErrorMessage    string            `json:"errorMessage,omitempty"`
+	CreatedAt       *metav1.Time      `json:"createdAt,omitempty"`       // Timestamp when the request was created
+	ExpiresAt       *metav1.Time      `json:"expiresAt,omitempty"`       // Timestamp when the request will expire
+	ChildResource   []ChildResource   `json:"childResource,omitempty"`   // Details of the associated resource
+	Approvals       []Approval        `json:"approvals,omitempty"`       // Approvals accepted by the controller
+	EscalationLevel int               `json:"escalationLevel,omitempty"` // Number of escalation tiers that became eligible
+	Extensions      []ExtensionRecord `json:"extensions,omitempty"`      // Outcome of every processed extension request
+	Revocation      *Revocation       `json:"revocation,omitempty"`      // Who revoked the request, when and why
+	ReviewState     string            `json:"reviewState,omitempty"`     // AwaitingReview or Reviewed, for break-glass requests only
+	Review          *Review           `json:"review,omitempty"`          // Review accepted by the controller
+	MergedInto      string            `json:"mergedInto,omitempty"`      // Active request this duplicate was merged into
+	QueuedAt        *metav1.Time      `json:"queuedAt,omitempty"`        // When the request entered the queue of its policy
+	QueuePosition   int               `json:"queuePosition,omitempty"`   // Position in the queue of its policy while Queued, starting at 1
+	Policy          string            `json:"policy,omitempty"`          // Policy the request is enforced under, named or selected
 }
 
 // +kubebuilder:object:root=true
@@ -53,5 +124,147 @@ func (in *SudoRequestStatus) DeepCopyInto(out *SudoRequestStatus) {
 		in, out := &in.ExpiresAt, &out.ExpiresAt
 		*out = (*in).DeepCopy()
 	}
+	if in.Approvals != nil {
+		in, out := &in.Approvals, &out.Approvals
+		*out = make([]Approval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Extensions != nil {
+		in, out := &in.Extensions, &out.Extensions
+		*out = make([]ExtensionRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Revocation != nil {
+		in, out := &in.Revocation, &out.Revocation
+		*out = new(Revocation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Review != nil {
+		in, out := &in.Review, &out.Review
+		*out = new(Review)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.QueuedAt != nil {
+		in, out := &in.QueuedAt, &out.QueuedAt
+		*out = (*in).DeepCopy()
+	}
 }
 
+func (in *SudoRequestSpec) DeepCopyInto(out *SudoRequestSpec) {
+	*out = *in
+	in.Requester.DeepCopyInto(&out.Requester)
+	if in.RoleRef != nil {
+		in, out := &in.RoleRef, &out.RoleRef
+		*out = new(rbacv1.RoleRef)
+		**out = **in
+	}
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Approvals != nil {
+		in, out := &in.Approvals, &out.Approvals
+		*out = make([]Approval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Extensions != nil {
+		in, out := &in.Extensions, &out.Extensions
+		*out = make([]ExtensionRequest, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Revocation != nil {
+		in, out := &in.Revocation, &out.Revocation
+		*out = new(Revocation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Reviews != nil {
+		in, out := &in.Reviews, &out.Reviews
+		*out = make([]Review, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ResourceNames != nil {
+		in, out := &in.ResourceNames, &out.ResourceNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *UserIdentity) DeepCopyInto(out *UserIdentity) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Extra != nil {
+		in, out := &in.Extra, &out.Extra
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			if val == nil {
+				(*out)[key] = nil
+				continue
+			}
+			(*out)[key] = make([]string, len(val))
+			copy((*out)[key], val)
+		}
+	}
+}
+
+func (in *Approval) DeepCopyInto(out *Approval) {
+	*out = *in
+	in.Approver.DeepCopyInto(&out.Approver)
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+func (in *ExtensionRequest) DeepCopyInto(out *ExtensionRequest) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+func (in *ExtensionRecord) DeepCopyInto(out *ExtensionRecord) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+func (in *Revocation) DeepCopyInto(out *Revocation) {
+	*out = *in
+	in.RevokedBy.DeepCopyInto(&out.RevokedBy)
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+func (in *Review) DeepCopyInto(out *Review) {
+	*out = *in
+	in.Reviewer.DeepCopyInto(&out.Reviewer)
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
@@ -8,10 +8,14 @@ import (
 
 // TemporaryRBACSpec defines the desired state of TemporaryRBAC
 type TemporaryRBACSpec struct {
-	Subjects        []rbacv1.Subject `json:"subjects"`                  // Subjects
-	RoleRef         rbacv1.RoleRef   `json:"roleRef"`                   // Role or ClusterRole reference
-	Duration        string           `json:"duration"`                  // Duration for the TemporaryRBAC
-	RetentionPolicy string           `json:"retentionPolicy,omitempty"` // delete or retain
+	Subjects        []rbacv1.Subject       `json:"subjects"`                  // Subjects
+	RoleRef         *rbacv1.RoleRef        `json:"roleRef,omitempty"`         // Role or ClusterRole reference
+	Roles           []RoleRefWithNamespace `json:"roles,omitempty"`           // Roles of an access profile, bound instead of the roleRef
+	Rules           []rbacv1.PolicyRule    `json:"rules,omitempty"`           // Rules of an ephemeral Role or ClusterRole, created and bound instead of the roleRef
+	Duration        string                 `json:"duration"`                  // Duration for the TemporaryRBAC
+	RetentionPolicy string                 `json:"retentionPolicy,omitempty"` // delete or retain
+	StartTime       *metav1.Time           `json:"startTime,omitempty"`       // When the bindings should be created, immediately if unset
+	Revoked         bool                   `json:"revoked,omitempty"`         // Ends the grant early, its bindings are deleted right away
 }
 
 // ChildResource represents details of the associated RoleBinding or ClusterRoleBinding
@@ -67,6 +71,27 @@ func (in *TemporaryRBACSpec) DeepCopyInto(out *TemporaryRBACSpec) {
 		*out = make([]rbacv1.Subject, len(*in))
 		copy(*out, *in)
 	}
+	if in.RoleRef != nil {
+		in, out := &in.RoleRef, &out.RoleRef
+		*out = new(rbacv1.RoleRef)
+		**out = **in
+	}
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]RoleRefWithNamespace, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy manually implements the deepcopy function for TemporaryRBACSpec.
@@ -0,0 +1,426 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	utils "github.com/guybal/tarbac/utils"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// defaultHistoryLimit is the number of finished occurrences kept when historyLimit is unset
+const defaultHistoryLimit = 3
+
+type ClusterRecurringSudoRequestReconciler struct {
+	client.Client
+	Scheme          *runtime.Scheme
+	Recorder        record.EventRecorder
+	DecisionClient  *utils.DecisionClient  // Optional external policy decision point
+	TicketValidator *utils.TicketValidator // Optional external validator of ticket references
+}
+
+// Reconcile starts the occurrences of a ClusterRecurringSudoRequest as they become due
+func (r *ClusterRecurringSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	now := time.Now()
+
+	utils.LogInfo(logger, "Reconciling ClusterRecurringSudoRequest", "name", req.Name)
+
+	var recurringRequest v1.ClusterRecurringSudoRequest
+	if err := r.Get(ctx, client.ObjectKey{Name: req.Name}, &recurringRequest); err != nil {
+		if apierrors.IsNotFound(err) {
+			utils.LogInfo(logger, "ClusterRecurringSudoRequest resource not found. Ignoring since it must have been deleted.", "name", req.Name)
+			return ctrl.Result{}, nil
+		}
+		utils.LogError(logger, err, "Unable to fetch ClusterRecurringSudoRequest", "name", req.Name)
+		return ctrl.Result{}, err
+	}
+
+	requestId := string(recurringRequest.ObjectMeta.UID)
+	recurringRequest.Status.RequestID = requestId
+
+	schedule, location, err := utils.ParseRecurrence(recurringRequest.Spec.Schedule, recurringRequest.Spec.TimeZone)
+	if err != nil {
+		return r.errorRequest(ctx, err, &recurringRequest, err.Error(), requestId)
+	}
+	duration, err := time.ParseDuration(recurringRequest.Spec.Duration)
+	if err != nil || duration <= 0 {
+		return r.errorRequest(ctx, err, &recurringRequest, fmt.Sprintf("Invalid duration: %s", recurringRequest.Spec.Duration), requestId)
+	}
+	recurringRequest.Status.ErrorMessage = ""
+
+	if err := r.pruneHistory(ctx, &recurringRequest, requestId); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to prune finished occurrences", requestId)
+		return ctrl.Result{}, err
+	}
+
+	if recurringRequest.Spec.Suspend {
+		if recurringRequest.Status.State != "Suspended" {
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterRecurringSudoRequest '%s' was suspended, no further occurrences will be started", recurringRequest.Name), requestId)
+			r.Recorder.Event(&recurringRequest, "Normal", "Suspended", eventMessage)
+		}
+		recurringRequest.Status.State = "Suspended"
+		recurringRequest.Status.NextScheduleTime = nil
+		if err := r.Status().Update(ctx, &recurringRequest); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update suspended ClusterRecurringSudoRequest status", requestId)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Occurrences missed while suspended are not caught up on
+	since := recurringRequest.CreationTimestamp.Time
+	if recurringRequest.Status.LastScheduleTime != nil {
+		since = recurringRequest.Status.LastScheduleTime.Time
+	}
+	if recurringRequest.Status.State == "Suspended" {
+		since = now
+	}
+
+	if due := utils.LatestOccurrence(schedule, location, since, now); !due.IsZero() {
+		recurringRequest.Status.LastScheduleTime = &metav1.Time{Time: due}
+		occurrence := r.startOccurrence(ctx, &recurringRequest, due, duration, now, requestId)
+		recurringRequest.Status.Occurrences = append(recurringRequest.Status.Occurrences, occurrence)
+	}
+
+	next := schedule.Next(now.In(location))
+	recurringRequest.Status.NextScheduleTime = &metav1.Time{Time: next}
+	recurringRequest.Status.State = "Active"
+	if err := r.Status().Update(ctx, &recurringRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update ClusterRecurringSudoRequest status", requestId)
+		return ctrl.Result{}, err
+	}
+
+	timeUntilNext := time.Until(next)
+	utils.LogInfoUID(logger, "ClusterRecurringSudoRequest reconciled, requeueing for the next occurrence", requestId, "nextScheduleTime", next)
+	if timeUntilNext < 1*time.Second {
+		return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+	}
+	return ctrl.Result{RequeueAfter: timeUntilNext}, nil
+}
+
+// startOccurrence validates a due occurrence against the referenced policy and creates its ClusterTemporaryRBAC,
+// or a TemporaryRBAC in every namespace allowed by the policy. Occurrences that are no longer allowed,
+// or whose window has already passed, are recorded as skipped.
+func (r *ClusterRecurringSudoRequestReconciler) startOccurrence(ctx context.Context, recurringRequest *v1.ClusterRecurringSudoRequest, due time.Time, duration time.Duration, now time.Time, requestId string) v1.Occurrence {
+	logger := log.FromContext(ctx)
+	occurrence := v1.Occurrence{ScheduledTime: metav1.Time{Time: due}}
+
+	remaining := due.Add(duration).Sub(now).Round(time.Second)
+	if remaining <= 0 {
+		return r.skipOccurrence(recurringRequest, occurrence, "The occurrence was missed entirely", requestId)
+	}
+
+	var clusterSudoPolicy v1.ClusterSudoPolicy
+	if err := r.Get(ctx, client.ObjectKey{Name: recurringRequest.Spec.Policy}, &clusterSudoPolicy); err != nil {
+		return r.skipOccurrence(recurringRequest, occurrence, "Referenced policy not found", requestId)
+	}
+	namespaces := clusterSudoPolicy.Status.Namespaces
+	if len(namespaces) == 0 {
+		return r.skipOccurrence(recurringRequest, occurrence, "No namespaces matched policy constraints", requestId)
+	}
+	if reason := r.validateOccurrence(ctx, recurringRequest, &clusterSudoPolicy, namespaces, duration, now, requestId); reason != "" {
+		return r.skipOccurrence(recurringRequest, occurrence, reason, requestId)
+	}
+
+	policyGrant, err := utils.ResolveGrant(ctx, r.Client, clusterSudoPolicy.Spec)
+	if err != nil {
+		return r.skipOccurrence(recurringRequest, occurrence, fmt.Sprintf("Failed to resolve the roles of policy '%s': %v", clusterSudoPolicy.Name, err), requestId)
+	}
+
+	grant := utils.GrantDuration(clusterSudoPolicy.Spec, remaining.String(), now)
+	name := utils.GenerateOccurrenceName(recurringRequest.Name, requestId, due)
+	labels := map[string]string{
+		"tarbac.io/recurring-request-id": requestId,
+		"tarbac.io/policy":               clusterSudoPolicy.Name,
+		"tarbac.io/policy-kind":          "ClusterSudoPolicy",
+	}
+	spec := v1.TemporaryRBACSpec{
+		Subjects: []rbacv1.Subject{recurringRequest.Spec.Subject},
+		RoleRef:  policyGrant.RoleRef,
+		Roles:    policyGrant.Roles,
+		Rules:    policyGrant.Rules,
+		Duration: grant,
+	}
+
+	annotations := utils.ReferenceAnnotations(recurringRequest.Spec.Justification, recurringRequest.Spec.Ticket)
+
+	var children []client.Object
+	if len(namespaces) == 1 && namespaces[0] == "*" {
+		children = append(children, &v1.ClusterTemporaryRBAC{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations},
+			Spec:       spec,
+		})
+	} else {
+		for _, namespace := range namespaces {
+			// Only the roles of an access profile and the inline rules that apply to the namespace are bound in it
+			namespaceGrant, ok := policyGrant.InNamespace(namespace)
+			if !ok {
+				continue
+			}
+			namespaceSpec := spec
+			namespaceSpec.Roles = namespaceGrant.Roles
+			namespaceSpec.Rules = namespaceGrant.Rules
+			children = append(children, &v1.TemporaryRBAC{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels, Annotations: annotations},
+				Spec:       namespaceSpec,
+			})
+		}
+	}
+
+	for _, child := range children {
+		kind := "TemporaryRBAC"
+		if _, ok := child.(*v1.ClusterTemporaryRBAC); ok {
+			kind = "ClusterTemporaryRBAC"
+		}
+		if err := controllerutil.SetControllerReference(recurringRequest, child, r.Scheme); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to set OwnerReference on "+kind, requestId)
+			continue
+		}
+		if err := r.Create(ctx, child); err != nil && !apierrors.IsAlreadyExists(err) {
+			utils.LogErrorUID(logger, err, "Failed to create "+kind, requestId, "namespace", child.GetNamespace())
+			continue
+		}
+		occurrence.ChildResource = append(occurrence.ChildResource, v1.ChildResource{
+			APIVersion: "tarbac.io/v1",
+			Kind:       kind,
+			Name:       child.GetName(),
+			Namespace:  child.GetNamespace(),
+		})
+	}
+	if len(occurrence.ChildResource) == 0 {
+		return r.skipOccurrence(recurringRequest, occurrence, "Failed to create any temporary RBAC resource", requestId)
+	}
+
+	occurrence.State = "Granted"
+	eventMessage := utils.FormatEventMessage(fmt.Sprintf("Occurrence due at %s granted %s '%s' policy '%s' for %s%s", due.Format(time.RFC3339), recurringRequest.Spec.Subject.Kind, recurringRequest.Spec.Subject.Name, clusterSudoPolicy.Name, grant, utils.DescribeReferences(recurringRequest.Spec.Justification, recurringRequest.Spec.Ticket)), requestId)
+	r.Recorder.Event(recurringRequest, "Normal", "OccurrenceStarted", eventMessage)
+	return occurrence
+}
+
+// validateOccurrence checks the creator and the subject of a recurring request against the policy as it is now,
+// and returns why the occurrence is not allowed, or an empty string when it is.
+func (r *ClusterRecurringSudoRequestReconciler) validateOccurrence(ctx context.Context, recurringRequest *v1.ClusterRecurringSudoRequest, clusterSudoPolicy *v1.ClusterSudoPolicy, namespaces []string, duration time.Duration, now time.Time, requestId string) string {
+	logger := log.FromContext(ctx)
+
+	if clusterSudoPolicy.Spec.ApprovalMode == "Manual" {
+		return fmt.Sprintf("Policy '%s' requires manual approval, which recurring requests do not support", clusterSudoPolicy.Name)
+	}
+
+	maxDuration, err := time.ParseDuration(clusterSudoPolicy.Spec.MaxDuration)
+	if err != nil || duration > maxDuration {
+		return fmt.Sprintf("Duration %s exceeds max allowed duration %s", duration, clusterSudoPolicy.Spec.MaxDuration)
+	}
+
+	schedule, err := utils.EvaluateSchedule(clusterSudoPolicy.Spec.Schedule, now)
+	if err != nil {
+		return fmt.Sprintf("Invalid schedule in policy spec: %v", err)
+	}
+	if !schedule.Allowed {
+		return fmt.Sprintf("Occurrence is outside the schedule of the policy: %s", schedule.Reason)
+	}
+
+	if err := utils.CheckReferences(clusterSudoPolicy.Spec, recurringRequest.Spec.Justification, recurringRequest.Spec.Ticket); err != nil {
+		return fmt.Sprintf("Occurrence does not satisfy the requirements of the policy: %v", err)
+	}
+
+	// The creator must be entitled to the grants it schedules, not only the subject
+	if reason := utils.CheckRecurringSubject(clusterSudoPolicy.Spec, recurringRequest.Spec.Creator, recurringRequest.Spec.Subject); reason != "" {
+		return reason
+	}
+
+	identity := utils.SubjectIdentity(recurringRequest.Spec.Subject)
+	if !utils.IsAllowedRequester(clusterSudoPolicy.Spec, identity) {
+		return "Subject not allowed by policy"
+	}
+	if err := utils.CheckRequiredAttributes(clusterSudoPolicy.Spec, identity); err != nil {
+		return fmt.Sprintf("Subject does not satisfy the attribute requirements of the policy: %v", err)
+	}
+
+	if err := utils.EvaluateConditions(clusterSudoPolicy.Spec, utils.ConditionInput{
+		Name:       recurringRequest.Name,
+		Policy:     clusterSudoPolicy.Name,
+		Duration:   duration,
+		Requester:  identity,
+		Namespaces: namespaces,
+		Now:        now,
+	}); err != nil {
+		return fmt.Sprintf("Occurrence does not satisfy the conditions of the policy: %v", err)
+	}
+
+	// No occurrence is started while an emergency lockdown covers it
+	lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{
+		Namespaces: namespaces,
+		Policy:     clusterSudoPolicy.Name,
+		PolicyKind: "ClusterSudoPolicy",
+		Users:      utils.SubjectUsers([]rbacv1.Subject{recurringRequest.Spec.Subject}),
+	})
+	if err != nil {
+		utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+		return fmt.Sprintf("Failed to check for lockdowns: %v", err)
+	}
+	if lockdown != nil {
+		return fmt.Sprintf("Lockdown '%s' is in place: %s", lockdown.Name, lockdown.Spec.Reason)
+	}
+
+	if r.DecisionClient != nil {
+		decision, err := r.DecisionClient.Decide(ctx, utils.DecisionInput{
+			Kind:          "ClusterRecurringSudoRequest",
+			Name:          recurringRequest.Name,
+			Policy:        clusterSudoPolicy.Name,
+			Duration:      recurringRequest.Spec.Duration,
+			Requester:     identity,
+			RoleRef:       clusterSudoPolicy.Spec.RoleRef,
+			AccessProfile: clusterSudoPolicy.Spec.AccessProfile,
+			Rules:         clusterSudoPolicy.Spec.Rules,
+			Namespaces:    namespaces,
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to query the external decision point", requestId, "allow", decision.Allow)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("External decision point could not be queried: %v", err), requestId)
+			r.Recorder.Event(recurringRequest, "Warning", "DecisionPointUnavailable", eventMessage)
+			if !decision.Allow {
+				return fmt.Sprintf("External decision point could not be queried: %v", err)
+			}
+		}
+		if !decision.Allow {
+			return fmt.Sprintf("Occurrence denied by the external decision point: %s", decision.Reason)
+		}
+	}
+
+	// The ticket must still be open when an occurrence starts
+	if r.TicketValidator != nil && recurringRequest.Spec.Ticket != "" {
+		result, err := r.TicketValidator.Validate(ctx, utils.TicketInput{
+			Ticket:    recurringRequest.Spec.Ticket,
+			Kind:      "ClusterRecurringSudoRequest",
+			Name:      recurringRequest.Name,
+			Policy:    clusterSudoPolicy.Name,
+			Requester: recurringRequest.Spec.Subject.Name,
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to validate ticket", requestId, "ticket", recurringRequest.Spec.Ticket)
+			return fmt.Sprintf("Ticket '%s' could not be validated: %v", recurringRequest.Spec.Ticket, err)
+		}
+		if !result.Valid {
+			return fmt.Sprintf("Ticket '%s' was rejected by the ticket validator: %s", recurringRequest.Spec.Ticket, result.Reason)
+		}
+	}
+	return ""
+}
+
+func (r *ClusterRecurringSudoRequestReconciler) skipOccurrence(recurringRequest *v1.ClusterRecurringSudoRequest, occurrence v1.Occurrence, message string, requestId string) v1.Occurrence {
+	occurrence.State = "Skipped"
+	occurrence.Message = message
+	eventMessage := utils.FormatEventMessage(fmt.Sprintf("Occurrence due at %s was skipped: %s", occurrence.ScheduledTime.Format(time.RFC3339), message), requestId)
+	r.Recorder.Event(recurringRequest, "Warning", "OccurrenceSkipped", eventMessage)
+	return occurrence
+}
+
+// pruneHistory drops the oldest occurrences beyond the history limit once all of their
+// temporary RBAC resources have expired, and deletes those resources.
+func (r *ClusterRecurringSudoRequestReconciler) pruneHistory(ctx context.Context, recurringRequest *v1.ClusterRecurringSudoRequest, requestId string) error {
+	logger := log.FromContext(ctx)
+	limit := defaultHistoryLimit
+	if recurringRequest.Spec.HistoryLimit != nil {
+		limit = int(*recurringRequest.Spec.HistoryLimit)
+	}
+
+	var kept []v1.Occurrence
+	excess := len(recurringRequest.Status.Occurrences) - limit
+	for _, occurrence := range recurringRequest.Status.Occurrences {
+		if excess <= 0 {
+			kept = append(kept, occurrence)
+			continue
+		}
+		finished, err := r.isFinished(ctx, occurrence)
+		if err != nil {
+			return err
+		}
+		if !finished {
+			kept = append(kept, occurrence)
+			continue
+		}
+		for _, child := range occurrence.ChildResource {
+			err := r.Delete(ctx, childObject(child))
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+		utils.LogInfoUID(logger, "Pruned finished occurrence", requestId, "scheduledTime", occurrence.ScheduledTime)
+		excess--
+	}
+	recurringRequest.Status.Occurrences = kept
+	return nil
+}
+
+// isFinished reports whether all temporary RBAC resources of an occurrence have expired, were revoked, failed or are gone
+func (r *ClusterRecurringSudoRequestReconciler) isFinished(ctx context.Context, occurrence v1.Occurrence) (bool, error) {
+	for _, child := range occurrence.ChildResource {
+		var state string
+		if child.Kind == "ClusterTemporaryRBAC" {
+			var clusterTemporaryRBAC v1.ClusterTemporaryRBAC
+			if err := r.Get(ctx, client.ObjectKey{Name: child.Name}, &clusterTemporaryRBAC); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return false, err
+			}
+			state = clusterTemporaryRBAC.Status.State
+		} else {
+			var temporaryRBAC v1.TemporaryRBAC
+			if err := r.Get(ctx, client.ObjectKey{Name: child.Name, Namespace: child.Namespace}, &temporaryRBAC); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return false, err
+			}
+			state = temporaryRBAC.Status.State
+		}
+		if !utils.IsFinishedGrantState(state) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// childObject returns an object that identifies the child resource of an occurrence
+func childObject(child v1.ChildResource) client.Object {
+	if child.Kind == "ClusterTemporaryRBAC" {
+		return &v1.ClusterTemporaryRBAC{ObjectMeta: metav1.ObjectMeta{Name: child.Name}}
+	}
+	return &v1.TemporaryRBAC{ObjectMeta: metav1.ObjectMeta{Name: child.Name, Namespace: child.Namespace}}
+}
+
+func (r *ClusterRecurringSudoRequestReconciler) errorRequest(ctx context.Context, err error, recurringRequest *v1.ClusterRecurringSudoRequest, message string, requestID string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	utils.LogErrorUID(logger, err, "ClusterRecurringSudoRequest Error", requestID, "errorMessage", message)
+	recurringRequest.Status.State = "Error"
+	recurringRequest.Status.ErrorMessage = message
+	recurringRequest.Status.NextScheduleTime = nil
+	if err := r.Status().Update(ctx, recurringRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update ClusterRecurringSudoRequest status to Error", requestID)
+		return ctrl.Result{}, err
+	}
+	eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterRecurringSudoRequest Error: %s", message), requestID)
+	r.Recorder.Event(recurringRequest, "Warning", "ClusterRecurringSudoRequestError", eventMessage)
+	return ctrl.Result{}, nil
+}
+
+func (r *ClusterRecurringSudoRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Scheme = mgr.GetScheme()
+	r.Recorder = mgr.GetEventRecorderFor("ClusterRecurringSudoRequestController")
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.ClusterRecurringSudoRequest{}).
+		Complete(r)
+}
@@ -10,10 +10,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	// 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -41,10 +44,98 @@ func (r *ClusterSudoPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	}
 
 	// Validate maxDuration
-	if _, err := time.ParseDuration(clusterSudoPolicy.Spec.MaxDuration); err != nil {
+	maxDuration, err := time.ParseDuration(clusterSudoPolicy.Spec.MaxDuration)
+	if err != nil {
 		return r.errorRequest(ctx, err, &clusterSudoPolicy, fmt.Sprintf("Invalid MaxDuration in ClusterSudoPolicy spec: %s", clusterSudoPolicy.Spec.MaxDuration))
 	}
 
+	// Validate maxTotalDuration
+	if clusterSudoPolicy.Spec.MaxTotalDuration != "" {
+		maxTotalDuration, err := time.ParseDuration(clusterSudoPolicy.Spec.MaxTotalDuration)
+		if err != nil {
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, fmt.Sprintf("Invalid maxTotalDuration in ClusterSudoPolicy spec: %s", clusterSudoPolicy.Spec.MaxTotalDuration))
+		}
+		if maxTotalDuration < maxDuration {
+			errorMessage := fmt.Sprintf("maxTotalDuration (%s) must not be shorter than maxDuration (%s)", maxTotalDuration, maxDuration)
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+		}
+	}
+
+	// Validate the granted role, access profile or inline rules
+	if err := utils.ValidatePolicyRoles(clusterSudoPolicy.Spec); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+	if err := utils.ValidateRules(clusterSudoPolicy.Spec.Rules, grantsClusterWide(clusterSudoPolicy.Spec)); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+	if err := utils.ValidateResourceNamePatterns(clusterSudoPolicy.Spec.AllowedResourceNames); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+	if clusterSudoPolicy.Spec.AccessProfile != "" {
+		if _, err := utils.GetAccessProfile(ctx, r.Client, clusterSudoPolicy.Spec.AccessProfile); err != nil {
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+		}
+	}
+
+	// Validate allowed subjects
+	if len(clusterSudoPolicy.Spec.AllowedUsers) == 0 && len(clusterSudoPolicy.Spec.AllowedGroups) == 0 && len(clusterSudoPolicy.Spec.Conditions) == 0 {
+		errorMessage := "either allowedUsers, allowedGroups or conditions must be set"
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+	}
+
+	// Validate attribute requirements
+	for _, requirement := range clusterSudoPolicy.Spec.RequiredAttributes {
+		if err := utils.ValidateAttributeRequirement(requirement); err != nil {
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+		}
+	}
+
+	// Validate conditions
+	if _, err := utils.CompileConditions(clusterSudoPolicy.Spec.Conditions); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+
+	// Validate schedule
+	if err := utils.ValidateSchedule(clusterSudoPolicy.Spec.Schedule); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+
+	// Validate approvers
+	if clusterSudoPolicy.Spec.ApprovalMode == "Manual" && len(clusterSudoPolicy.Spec.Approvers) == 0 {
+		errorMessage := "approvers must be set when approvalMode is Manual"
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+	}
+	if clusterSudoPolicy.Spec.RequiredApprovals < 0 {
+		errorMessage := fmt.Sprintf("requiredApprovals must not be negative: %d", clusterSudoPolicy.Spec.RequiredApprovals)
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+	}
+	if approvers := utils.AllApprovers(clusterSudoPolicy.Spec); clusterSudoPolicy.Spec.ApprovalMode == "Manual" && !hasGroupApprover(approvers) && utils.RequiredApprovals(clusterSudoPolicy.Spec) > len(approvers) {
+		errorMessage := fmt.Sprintf("requiredApprovals (%d) exceeds the number of approvers (%d)", utils.RequiredApprovals(clusterSudoPolicy.Spec), len(approvers))
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+	}
+
+	// Validate pending TTL and escalation tiers
+	if clusterSudoPolicy.Spec.PendingTTL != "" {
+		if _, err := time.ParseDuration(clusterSudoPolicy.Spec.PendingTTL); err != nil {
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, fmt.Sprintf("Invalid pendingTTL in ClusterSudoPolicy spec: %s", clusterSudoPolicy.Spec.PendingTTL))
+		}
+	}
+	for _, tier := range clusterSudoPolicy.Spec.Escalations {
+		if _, err := time.ParseDuration(tier.After); err != nil {
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, fmt.Sprintf("Invalid escalation delay in ClusterSudoPolicy spec: %s", tier.After))
+		}
+		if len(tier.Approvers) == 0 {
+			errorMessage := fmt.Sprintf("escalation tier after %s has no approvers", tier.After)
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+		}
+	}
+
 	if clusterSudoPolicy.Spec.AllowedNamespaces != nil && clusterSudoPolicy.Spec.AllowedNamespacesSelector != nil {
 		errorMessage := "both allowedNamespaces and allowedNamespacesSelector cannot be set simultaneously"
 		err := fmt.Errorf("%s", errorMessage)
@@ -76,8 +167,55 @@ func (r *ClusterSudoPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		}
 	}
 
+	// Validate justification and ticket requirements
+	if err := utils.ValidateTextRequirement("justification", clusterSudoPolicy.Spec.Justification); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+	if err := utils.ValidateTextRequirement("ticket", clusterSudoPolicy.Spec.Ticket); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+
+	// Validate quotas and cooldown
+	if err := utils.ValidateQuotas(clusterSudoPolicy.Spec); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+
+	// Validate concurrency limits
+	if err := utils.ValidateConcurrencyLimits(clusterSudoPolicy.Spec); err != nil {
+		return r.errorRequest(ctx, err, &clusterSudoPolicy, err.Error())
+	}
+
+	// Validate break-glass
+	if clusterSudoPolicy.Spec.BreakGlass != nil {
+		if len(utils.BreakGlassReviewers(clusterSudoPolicy.Spec)) == 0 {
+			errorMessage := "breakGlass.reviewers or approvers must be set to review break-glass requests"
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+		}
+		if clusterSudoPolicy.Spec.BreakGlass.MaxUnreviewed < 0 {
+			errorMessage := fmt.Sprintf("breakGlass.maxUnreviewed must not be negative: %d", clusterSudoPolicy.Spec.BreakGlass.MaxUnreviewed)
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &clusterSudoPolicy, errorMessage)
+		}
+	}
+
+	// Report the policy as locked down while an emergency lockdown covers it
+	lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{Namespaces: namespaces, Policy: clusterSudoPolicy.Name, PolicyKind: "ClusterSudoPolicy"})
+	if err != nil {
+		utils.LogError(logger, err, "Failed to check for lockdowns", "name", clusterSudoPolicy.Name)
+		return ctrl.Result{}, err
+	}
+	state := "Active"
+	if lockdown != nil {
+		state = "LockedDown"
+		if clusterSudoPolicy.Status.State != "LockedDown" {
+			eventMessage := fmt.Sprintf("Requests for policy '%s' are on hold while lockdown '%s' is in place: %s", clusterSudoPolicy.Name, lockdown.Name, lockdown.Spec.Reason)
+			r.Recorder.Event(&clusterSudoPolicy, "Warning", "LockedDown", eventMessage)
+		}
+	}
+
 	// Update ClusterSudoPolicy status
-	clusterSudoPolicy.Status.State = "Active"
+	clusterSudoPolicy.Status.State = state
 	clusterSudoPolicy.Status.Namespaces = namespaces
 	if err := r.Status().Update(ctx, &clusterSudoPolicy); err != nil {
 		return r.errorRequest(ctx, err, &clusterSudoPolicy, "Failed to update ClusterSudoPolicy status")
@@ -110,10 +248,63 @@ func (r *ClusterSudoPolicyReconciler) errorRequest(ctx context.Context, err erro
 	return ctrl.Result{}, nil
 }
 
+// hasGroupApprover reports whether any approver is a group, whose size is unknown to the controller
+func hasGroupApprover(approvers []v1.ApproverRef) bool {
+	for _, approver := range approvers {
+		if approver.Kind == "Group" {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsClusterWide reports whether a policy grants access to the whole cluster rather than to namespaces
+func grantsClusterWide(policySpec v1.SudoPolicySpec) bool {
+	for _, namespace := range policySpec.AllowedNamespaces {
+		if namespace == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// policiesForLockdown enqueues every ClusterSudoPolicy when a lockdown is created, changed or lifted
+func (r *ClusterSudoPolicyReconciler) policiesForLockdown(ctx context.Context, lockdown client.Object) []reconcile.Request {
+	var policies v1.ClusterSudoPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list ClusterSudoPolicies for lockdown", "lockdown", lockdown.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range policies.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+	}
+	return requests
+}
+
+// policiesForAccessProfile enqueues the ClusterSudoPolicies granting an access profile when it is created, changed or deleted
+func (r *ClusterSudoPolicyReconciler) policiesForAccessProfile(ctx context.Context, profile client.Object) []reconcile.Request {
+	var policies v1.ClusterSudoPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list ClusterSudoPolicies for access profile", "accessProfile", profile.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range policies.Items {
+		if item.Spec.AccessProfile != profile.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterSudoPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("ClusterSudoPolicyController")
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.ClusterSudoPolicy{}).
+		Watches(&v1.Lockdown{}, handler.EnqueueRequestsFromMapFunc(r.policiesForLockdown)).
+		Watches(&v1.AccessProfile{}, handler.EnqueueRequestsFromMapFunc(r.policiesForAccessProfile)).
 		Complete(r)
 }
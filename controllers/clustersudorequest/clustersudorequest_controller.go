@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -12,17 +13,23 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type ClusterSudoRequestReconciler struct {
 	client.Client
-	Scheme   *runtime.Scheme
-	Recorder record.EventRecorder
+	Scheme          *runtime.Scheme
+	Recorder        record.EventRecorder
+	DecisionClient  *utils.DecisionClient  // Optional external policy decision point
+	Notifier        *utils.Notifier        // Optional endpoint notified of break-glass requests
+	TicketValidator *utils.TicketValidator // Optional external validator of ticket references
 }
 
 func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -43,12 +50,25 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 
 	requestId = r.getRequestID(&clusterSudoRequest)
 
-	// Skip reconciliation for Expires / Rejected requests
-	if clusterSudoRequest.Status.State == "Rejected" || clusterSudoRequest.Status.State == "Expired" {
+	// Break-glass requests are reviewed after the fact, whatever state they are in
+	if clusterSudoRequest.Status.ReviewState == "AwaitingReview" && len(clusterSudoRequest.Spec.Reviews) > 0 {
+		if err := r.processReview(ctx, &clusterSudoRequest, requestId); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to process review of break-glass ClusterSudoRequest", requestId)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Skip reconciliation for Expires / Rejected / Revoked / Merged requests
+	if clusterSudoRequest.Status.State == "Rejected" || clusterSudoRequest.Status.State == "Expired" || clusterSudoRequest.Status.State == "Revoked" || clusterSudoRequest.Status.State == "Merged" {
 		utils.LogInfoUID(logger, "ClusterSudoRequest already processed", requestId, "state", clusterSudoRequest.Status.State)
 		return ctrl.Result{}, nil
 	}
 
+	// End the request early when it was revoked
+	if clusterSudoRequest.Spec.Revocation != nil {
+		return r.revokeRequest(ctx, &clusterSudoRequest, clusterSudoRequest.Spec.Revocation, requestId)
+	}
+
 	// Validate duration
 	duration, err := time.ParseDuration(clusterSudoRequest.Spec.Duration)
 	if err != nil || duration <= 0 {
@@ -56,24 +76,62 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 	}
 
 	// Validate requester
-	requester := clusterSudoRequest.Annotations["tarbac.io/requester"]
+	requester := clusterSudoRequest.Spec.Requester.Username
 	if requester == "" {
 		return r.rejectRequest(ctx, &clusterSudoRequest, "Requester information is missing", logger, requestId)
 	}
 
-	// Validate referenced policy exists
-	var clusterSudoPolicy v1.ClusterSudoPolicy
-	if err := r.Get(ctx, client.ObjectKey{Name: clusterSudoRequest.Spec.Policy}, &clusterSudoPolicy); err != nil {
-		return r.rejectRequest(ctx, &clusterSudoRequest, "Referenced policy not found", logger, requestId)
+	// Select the policy of requests that ask for a role instead of naming a policy
+	policyNames := utils.RequestPolicies(&clusterSudoRequest.Spec, &clusterSudoRequest.Status)
+	if len(policyNames) == 0 {
+		if clusterSudoRequest.Spec.RoleRef == nil {
+			return r.rejectRequest(ctx, &clusterSudoRequest, "Either a policy, policies or a roleRef must be requested", logger, requestId)
+		}
+		selected, err := r.selectPolicy(ctx, &clusterSudoRequest, duration)
+		if err != nil {
+			return r.errorRequest(ctx, err, &clusterSudoRequest, "Failed to list policies for the requested role", requestId)
+		}
+		if selected == "" {
+			return r.rejectRequest(ctx, &clusterSudoRequest, fmt.Sprintf("No policy permits %s for this request", utils.DescribeRoleRef(*clusterSudoRequest.Spec.RoleRef)), logger, requestId)
+		}
+		policyNames = []string{selected}
+	}
+	if err := utils.ValidateBundle(policyNames); err != nil {
+		return r.rejectRequest(ctx, &clusterSudoRequest, fmt.Sprintf("Invalid policy bundle: %v", err), logger, requestId)
+	}
+	if len(policyNames) > 1 && (len(clusterSudoRequest.Spec.Rules) > 0 || len(clusterSudoRequest.Spec.ResourceNames) > 0) {
+		return r.rejectRequest(ctx, &clusterSudoRequest, "Rules and resource names cannot be requested for a policy bundle", logger, requestId)
+	}
+
+	// Validate referenced policies exist, a bundle is enforced under all of its policies
+	clusterSudoPolicies := make([]v1.ClusterSudoPolicy, len(policyNames))
+	for i, policyName := range policyNames {
+		if err := r.Get(ctx, client.ObjectKey{Name: policyName}, &clusterSudoPolicies[i]); err != nil {
+			return r.rejectRequest(ctx, &clusterSudoRequest, fmt.Sprintf("Referenced policy '%s' not found", policyName), logger, requestId)
+		}
 	}
+	bundle := len(clusterSudoPolicies) > 1
 
 	// Initial State
 	if clusterSudoRequest.Status.State == "" {
-		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' submitted a ClusterSudoRequest for policy '%s' for a duration of %s", requester, clusterSudoPolicy.Name, duration), requestId)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' submitted a ClusterSudoRequest for %s for a duration of %s", requester, utils.DescribePolicies(policyNames), duration), requestId)
 		r.Recorder.Event(&clusterSudoRequest, "Normal", "Submitted", eventMessage)
+		if clusterSudoRequest.Spec.RoleRef != nil {
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Policy '%s' was selected for %s", policyNames[0], utils.DescribeRoleRef(*clusterSudoRequest.Spec.RoleRef)), requestId)
+			r.Recorder.Event(&clusterSudoRequest, "Normal", "PolicySelected", eventMessage)
+		}
+		for _, clusterSudoPolicy := range clusterSudoPolicies {
+			if clusterSudoPolicy.Spec.ApprovalMode == "Manual" {
+				eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' is waiting for approval by an approver of policy '%s'", requester, clusterSudoPolicy.Name), requestId)
+				r.Recorder.Event(&clusterSudoRequest, "Normal", "AwaitingApproval", eventMessage)
+			}
+		}
 		// r.Recorder.Event(&clusterSudoRequest, "Normal", "Submitted", fmt.Sprintf("User %s submitted a ClusterSudoRequest for policy %s for a duration of %s [UID: %s]", requester, clusterSudoRequest.Spec.Policy, duration, requestId))
 		clusterSudoRequest.Status.State = "Pending"
 		clusterSudoRequest.Status.RequestID = requestId
+		if !bundle {
+			clusterSudoRequest.Status.Policy = policyNames[0]
+		}
 		if err := r.Client.Status().Update(ctx, &clusterSudoRequest); err != nil {
 			return ctrl.Result{}, err
 		}
@@ -91,48 +149,81 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		}
 	}
 
-	if clusterSudoRequest.Status.State == "Pending" {
-
-		maxDuration, err := time.ParseDuration(clusterSudoPolicy.Spec.MaxDuration)
-		if err != nil || duration > maxDuration {
-			return r.rejectRequest(ctx, &clusterSudoRequest, fmt.Sprintf("Requested duration %s exceeds max allowed duration %s", duration, maxDuration), logger, requestId)
-		}
+	// Queued requests are checked again until a grant of the policy ends
+	if clusterSudoRequest.Status.State == "Pending" || clusterSudoRequest.Status.State == "Queued" {
 
-		if !r.validateRequester(clusterSudoPolicy, requester) {
-			return r.rejectRequest(ctx, &clusterSudoRequest, "User not allowed by policy", logger, requestId)
+		if startTime := clusterSudoRequest.Spec.StartTime; startTime != nil && !startTime.Add(duration).After(time.Now()) {
+			return r.rejectRequest(ctx, &clusterSudoRequest, fmt.Sprintf("Requested access window starting at %s has already ended", startTime.Format(time.RFC3339)), logger, requestId)
 		}
 
-		namespaces, err := r.getAllowedNamespaces(&clusterSudoPolicy)
-		if err != nil {
-			utils.LogErrorUID(logger, err, "Failed to retrieve allowed namespaces", requestId)
-			return ctrl.Result{}, err
+		// The request is granted only when every policy of a bundle grants it
+		namespaces := make([][]string, len(clusterSudoPolicies))
+		for i := range clusterSudoPolicies {
+			policyNamespaces, granted, result, err := r.checkPolicy(ctx, &clusterSudoRequest, &clusterSudoPolicies[i], bundle, duration, requestId)
+			if !granted {
+				return result, err
+			}
+			namespaces[i] = policyNamespaces
 		}
 
 		if clusterSudoRequest.Status.ChildResource == nil {
 			clusterSudoRequest.Status.ChildResource = []v1.ChildResource{}
 		}
 
-		if len(namespaces) == 0 {
-			return r.rejectRequest(ctx, &clusterSudoRequest, "No namespaces matched policy constraints", logger, requestId)
+		if clusterSudoRequest.Status.State == "Queued" {
+			clusterSudoRequest.Status.QueuePosition = 0
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' left the queue of %s", requester, utils.DescribePolicies(policyNames)), requestId)
+			r.Recorder.Event(&clusterSudoRequest, "Normal", "Dequeued", eventMessage)
+		}
+
+		// Break-glass requests await review from the moment they are granted, the review state is persisted with the grant
+		if clusterSudoRequest.Spec.BreakGlass {
+			clusterSudoRequest.Status.ReviewState = "AwaitingReview"
 		}
-		if len(namespaces) == 1 && namespaces[0] == "*" {
-			eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' was approved by '%s' ClusterSudoPolicy", requester, clusterSudoPolicy.Name), requestId)
-			r.Recorder.Event(&clusterSudoRequest, "Normal", "Approved", eventMessage)
-			// r.Recorder.Event(&clusterSudoRequest, "Normal", "Approved", fmt.Sprintf("User '%s' was approved by '%s' ClusterSudoPolicy [UID: %s]", requester, clusterSudoPolicy.Name, requestId))
-			return r.createClusterTemporaryRBAC(ctx, &clusterSudoRequest, &clusterSudoPolicy, logger, requestId)
+
+		approvedBy := fmt.Sprintf("'%s' ClusterSudoPolicy", policyNames[0])
+		if bundle {
+			approvedBy = fmt.Sprintf("all of ClusterSudoPolicies '%s'", strings.Join(policyNames, "', '"))
 		}
-		if len(namespaces) >= 1 {
-			eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' was approved by '%s' ClusterSudoPolicy", requester, clusterSudoPolicy.Name), requestId)
-			r.Recorder.Event(&clusterSudoRequest, "Normal", "Approved", eventMessage)
-			// r.Recorder.Event(&clusterSudoRequest, "Normal", "Approved", fmt.Sprintf("User '%s' was approved by '%s' ClusterSudoPolicy [UID: %s]", requester, clusterSudoPolicy.Name, requestId))
-			return r.createTemporaryRBACsForNamespaces(ctx, &clusterSudoRequest, namespaces, &clusterSudoPolicy, requester, logger, requestId)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' was approved by %s%s", requester, approvedBy, utils.DescribeReferences(clusterSudoRequest.Spec.Justification, clusterSudoRequest.Spec.Ticket)), requestId)
+		r.Recorder.Event(&clusterSudoRequest, "Normal", "Approved", eventMessage)
+		// r.Recorder.Event(&clusterSudoRequest, "Normal", "Approved", fmt.Sprintf("User '%s' was approved by '%s' ClusterSudoPolicy [UID: %s]", requester, clusterSudoPolicy.Name, requestId))
+		result, err := r.grantAccess(ctx, &clusterSudoRequest, clusterSudoPolicies, namespaces, requester, logger, requestId)
+		if err != nil || clusterSudoRequest.Status.State != "Approved" || !clusterSudoRequest.Spec.BreakGlass {
+			return result, err
 		}
+		// Only label and announce the request once its grant is persisted, so that the label update cannot drop it
+		if err := r.markBreakGlass(ctx, &clusterSudoRequest, policyNames, requester, requestId); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to mark break-glass ClusterSudoRequest for review", requestId)
+			return ctrl.Result{}, err
+		}
+		return result, nil
 	}
 
 	if clusterSudoRequest.Status.State == "Approved" {
 
 		utils.LogInfoUID(logger, "ClusterSudoRequest is already approved, validating child resources", requestId)
 
+		// Mark a break-glass request whose grant was persisted before it could be labelled for review
+		if clusterSudoRequest.Spec.BreakGlass && clusterSudoRequest.Status.ReviewState == "AwaitingReview" {
+			if err := r.markBreakGlass(ctx, &clusterSudoRequest, policyNames, requester, requestId); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to mark break-glass ClusterSudoRequest for review", requestId)
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Revoke the request when an emergency lockdown covers any of its policies
+		for _, policyName := range policyNames {
+			lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{Namespaces: grantedNamespaces(&clusterSudoRequest), Policy: policyName, PolicyKind: "ClusterSudoPolicy", Users: []string{requester}})
+			if err != nil {
+				utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+				return ctrl.Result{}, err
+			}
+			if lockdown != nil {
+				return r.revokeRequest(ctx, &clusterSudoRequest, utils.LockdownRevocation(lockdown), requestId)
+			}
+		}
+
 		for _, childResource := range clusterSudoRequest.Status.ChildResource {
 
 			if childResource.Name == "" {
@@ -175,7 +266,7 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 					}
 					// r.Recorder.Event(&clusterSudoRequest, "Warning", "Expired", fmt.Sprintf("ClusterSudoRequest Expired for User %s, revoked permissions for policy %s [UID: %s]", clusterSudoRequest.Annotations["tarbac.io/requester"], clusterSudoRequest.Spec.Policy, clusterSudoRequest.Status.RequestID))
 
-					eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest Expired for User '%s', revoked permissions for policy '%s'", requester, clusterSudoRequest.Spec.Policy), requestId)
+					eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest Expired for User '%s', revoked permissions for %s", requester, utils.DescribePolicies(policyNames)), requestId)
 					r.Recorder.Event(&clusterSudoRequest, "Warning", "MissingChildResource", eventMessage)
 
 					utils.LogInfoUID(logger, "ClusterSudoRequest has expired", requestId, "name", clusterSudoRequest.Name)
@@ -187,7 +278,7 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 						return ctrl.Result{}, err
 					}
 					// r.Recorder.Event(&clusterSudoRequest, "Error", "Error", fmt.Sprintf("Error detected while processing ClusterSudoRequest for User '%s' and policy '%s' [UID: %s]", clusterSudoRequest.Annotations["tarbac.io/requester"], clusterSudoRequest.Spec.Policy, clusterSudoRequest.Status.RequestID))
-					eventMessage := utils.FormatEventMessage(fmt.Sprintf("Error detected while processing ClusterSudoRequest for User '%s' and policy '%s'", requester, clusterSudoRequest.Spec.Policy), requestId)
+					eventMessage := utils.FormatEventMessage(fmt.Sprintf("Error detected while processing ClusterSudoRequest for User '%s' and %s", requester, utils.DescribePolicies(policyNames)), requestId)
 					r.Recorder.Event(&clusterSudoRequest, "Error", "Error", eventMessage)
 
 					utils.LogInfoUID(logger, "ClusterSudoRequest has errors", requestId, "name", clusterSudoRequest.Name)
@@ -226,7 +317,7 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 					}
 					// r.Recorder.Event(&clusterSudoRequest, "Warning", "Expired", fmt.Sprintf("ClusterSudoRequest Expired for User %s, revoked permissions for policy %s [UID: %s]", requester, clusterSudoRequest.Spec.Policy, requestId))
 
-					eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest Expired for User '%s', revoked permissions for policy '%s'", requester, clusterSudoRequest.Spec.Policy), requestId)
+					eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest Expired for User '%s', revoked permissions for %s", requester, utils.DescribePolicies(policyNames)), requestId)
 					r.Recorder.Event(&clusterSudoRequest, "Warning", "Expired", eventMessage)
 
 					utils.LogInfoUID(logger, "ClusterSudoRequest has expired", requestId, "name", clusterSudoRequest.Name)
@@ -240,7 +331,7 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 					}
 					// r.Recorder.Event(&clusterSudoRequest, "Error", "Error", fmt.Sprintf("Error detected while processing ClusterSudoRequest for User '%s' and policy '%s' [UID: %s]", requester, clusterSudoRequest.Spec.Policy, requestId))
 
-					eventMessage := utils.FormatEventMessage(fmt.Sprintf("Error detected while processing ClusterSudoRequest for User '%s' and policy '%s'", requester, clusterSudoRequest.Spec.Policy), requestId)
+					eventMessage := utils.FormatEventMessage(fmt.Sprintf("Error detected while processing ClusterSudoRequest for User '%s' and %s", requester, utils.DescribePolicies(policyNames)), requestId)
 					r.Recorder.Event(&clusterSudoRequest, "Error", "Error", eventMessage)
 
 					utils.LogInfoUID(logger, "ClusterSudoRequest has errors", requestId, "name", clusterSudoRequest.Name)
@@ -249,6 +340,12 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 			}
 		}
 
+		// Extend the grant when requested
+		if err := r.processExtensions(ctx, &clusterSudoRequest, clusterSudoPolicies, requester, requestId); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to extend ClusterSudoRequest", requestId)
+			return ctrl.Result{}, err
+		}
+
 		// Update the ClusterSudoRequest status
 		if err := r.Client.Status().Update(ctx, &clusterSudoRequest); err != nil {
 			utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status after child resource validation", requestId)
@@ -271,7 +368,7 @@ func (r *ClusterSudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 			return ctrl.Result{}, err
 		}
 
-		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of User '%s' for policy '%s' expired", requester, clusterSudoPolicy.Name), requestId)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of User '%s' for %s expired", requester, utils.DescribePolicies(policyNames)), requestId)
 		r.Recorder.Event(&clusterSudoRequest, "Warning", "Expired", eventMessage)
 
 		// r.Recorder.Event(&clusterSudoRequest, "Warning", "Expired", fmt.Sprintf("ClusterSudoRequest of user '%s' for policy '%s' expired [UID: %s]", requester, clusterSudoPolicy.Name, clusterSudoRequest.Status.RequestID))
@@ -284,14 +381,412 @@ func (r *ClusterSudoRequestReconciler) getAllowedNamespaces(clusterSudoPolicy *v
 	return clusterSudoPolicy.Status.Namespaces, nil
 }
 
-func (r *ClusterSudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, namespaces []string, clusterSudoPolicy *v1.ClusterSudoPolicy, requester string, logger logr.Logger, requestId string) (ctrl.Result, error) {
+// grantDuration returns the duration granted to a request from its activation, which may be cut short by the schedule
+// of its policies. All the policies of a bundle share the shortest duration, so that they expire together.
+func (r *ClusterSudoRequestReconciler) grantDuration(clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicies []v1.ClusterSudoPolicy, requestId string) string {
+	if clusterSudoRequest.Spec.BreakGlass {
+		// Break-glass access is not bound to the schedule of the policy
+		return clusterSudoRequest.Spec.Duration
+	}
+	durations := make([]string, 0, len(clusterSudoPolicies))
+	for _, clusterSudoPolicy := range clusterSudoPolicies {
+		duration := utils.GrantDuration(clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Duration, utils.ActivationTime(clusterSudoRequest.Spec.StartTime, time.Now()))
+		if duration != clusterSudoRequest.Spec.Duration {
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Requested duration %s was cut short to %s by the schedule of policy '%s'", clusterSudoRequest.Spec.Duration, duration, clusterSudoPolicy.Name), requestId)
+			r.Recorder.Event(clusterSudoRequest, "Normal", "GrantShortened", eventMessage)
+		}
+		durations = append(durations, duration)
+	}
+	return utils.ShortestDuration(durations)
+}
+
+// processExtensions handles the extension requests of an approved ClusterSudoRequest in order. Each extension is validated
+// against the maxDuration and maxTotalDuration of every policy of the request and, for each policy requiring manual
+// approval, waits for a new quorum of approvals given after it was requested. Granted extensions push out the expiration
+// of the request and of all of its child resources, and every outcome is recorded in the status.
+func (r *ClusterSudoRequestReconciler) processExtensions(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicies []v1.ClusterSudoPolicy, requester string, requestId string) error {
+	logger := log.FromContext(ctx)
+
+	for i := utils.ProcessedExtensions(clusterSudoRequest.Status.Extensions); i < len(clusterSudoRequest.Spec.Extensions); i++ {
+		extension := clusterSudoRequest.Spec.Extensions[i]
+		if clusterSudoRequest.Status.CreatedAt == nil || clusterSudoRequest.Status.ExpiresAt == nil {
+			utils.LogInfoUID(logger, "Extension requested before the grant was created, waiting", requestId)
+			return nil
+		}
+		now := time.Now()
+		record := v1.ExtensionRecord{Duration: extension.Duration, Timestamp: metav1.Time{Time: now}}
+
+		var duration time.Duration
+		var err error
+		for _, clusterSudoPolicy := range clusterSudoPolicies {
+			if duration, err = utils.CheckExtension(clusterSudoPolicy.Spec, extension.Duration, clusterSudoRequest.Status.CreatedAt.Time, clusterSudoRequest.Status.ExpiresAt.Time); err != nil {
+				if len(clusterSudoPolicies) > 1 {
+					err = fmt.Errorf("policy '%s': %v", clusterSudoPolicy.Name, err)
+				}
+				break
+			}
+		}
+		if err == nil && !now.Before(clusterSudoRequest.Status.ExpiresAt.Time) {
+			err = fmt.Errorf("the grant has already expired")
+		}
+		if err != nil {
+			record.State = "Rejected"
+			record.Message = err.Error()
+			clusterSudoRequest.Status.Extensions = append(clusterSudoRequest.Status.Extensions, record)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Extension of %s requested by user '%s' was rejected: %v", extension.Duration, requester, err), requestId)
+			r.Recorder.Event(clusterSudoRequest, "Warning", "ExtensionRejected", eventMessage)
+			continue
+		}
+
+		for _, clusterSudoPolicy := range clusterSudoPolicies {
+			if clusterSudoPolicy.Spec.ApprovalMode != "Manual" {
+				continue
+			}
+			approvals := utils.EligibleApprovals(clusterSudoPolicy.Spec, utils.ApprovalsSince(clusterSudoRequest.Spec.Approvals, extension.Timestamp.Time), requester, extension.Timestamp.Time)
+			if required := utils.RequiredApprovals(clusterSudoPolicy.Spec); len(approvals) < required {
+				utils.LogInfoUID(logger, "Extension is waiting for approval", requestId, "extension", extension.Duration, "policy", clusterSudoPolicy.Name, "approvals", len(approvals), "requiredApprovals", required)
+				return nil
+			}
+		}
+
+		expiresAt := metav1.Time{Time: clusterSudoRequest.Status.ExpiresAt.Add(duration)}
+		if err := r.extendGrant(ctx, clusterSudoRequest, expiresAt); err != nil {
+			return err
+		}
+
+		record.State = "Granted"
+		record.ExpiresAt = expiresAt.DeepCopy()
+		clusterSudoRequest.Status.Extensions = append(clusterSudoRequest.Status.Extensions, record)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' was extended by %s until %s", requester, duration, expiresAt.Format(time.RFC3339)), requestId)
+		r.Recorder.Event(clusterSudoRequest, "Normal", "Extended", eventMessage)
+	}
+	return nil
+}
+
+// extendGrant moves the expiration of the grant of a ClusterSudoRequest and of all of its children to expiresAt.
+func (r *ClusterSudoRequestReconciler) extendGrant(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, expiresAt metav1.Time) error {
+	for _, child := range clusterSudoRequest.Status.ChildResource {
+		switch child.Kind {
+		case "TemporaryRBAC":
+			var temporaryRBAC v1.TemporaryRBAC
+			if err := r.Get(ctx, client.ObjectKey{Name: child.Name, Namespace: child.Namespace}, &temporaryRBAC); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			temporaryRBAC.Status.ExpiresAt = expiresAt.DeepCopy()
+			if err := r.Status().Update(ctx, &temporaryRBAC); err != nil {
+				return err
+			}
+		case "ClusterTemporaryRBAC":
+			var clusterTemporaryRBAC v1.ClusterTemporaryRBAC
+			if err := r.Get(ctx, client.ObjectKey{Name: child.Name}, &clusterTemporaryRBAC); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			clusterTemporaryRBAC.Status.ExpiresAt = expiresAt.DeepCopy()
+			if err := r.Status().Update(ctx, &clusterTemporaryRBAC); err != nil {
+				return err
+			}
+		}
+	}
+	clusterSudoRequest.Status.ExpiresAt = expiresAt.DeepCopy()
+	return nil
+}
+
+// mergeRequest merges a duplicate ClusterSudoRequest into the active request of the same user, extending the active grant
+// when the duplicate would have lasted longer. The duplicate moves to the terminal Merged state.
+func (r *ClusterSudoRequestReconciler) mergeRequest(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, target string, clusterSudoPolicy *v1.ClusterSudoPolicy, duration time.Duration, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var existing v1.ClusterSudoRequest
+	if err := r.Get(ctx, client.ObjectKey{Name: target}, &existing); err != nil {
+		return r.errorRequest(ctx, err, clusterSudoRequest, "Failed to fetch the ClusterSudoRequest to merge into", requestId)
+	}
+	if existing.Status.CreatedAt == nil || existing.Status.ExpiresAt == nil {
+		utils.LogInfoUID(logger, "Waiting for the grant of the ClusterSudoRequest to merge into", requestId, "target", target)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	expiresAt := utils.ActivationTime(clusterSudoRequest.Spec.StartTime, time.Now()).Add(duration)
+	if expiresAt.After(existing.Status.ExpiresAt.Time) {
+		extension := expiresAt.Sub(existing.Status.ExpiresAt.Time).Round(time.Second)
+		if _, err := utils.CheckExtension(clusterSudoPolicy.Spec, extension.String(), existing.Status.CreatedAt.Time, existing.Status.ExpiresAt.Time); err != nil {
+			return r.rejectRequest(ctx, clusterSudoRequest, fmt.Sprintf("Duplicate of active ClusterSudoRequest '%s', which cannot be extended: %v", target, err), logger, requestId)
+		}
+		extendedAt := metav1.Time{Time: existing.Status.ExpiresAt.Add(extension)}
+		if err := r.extendGrant(ctx, &existing, extendedAt); err != nil {
+			return r.errorRequest(ctx, err, clusterSudoRequest, "Failed to extend the ClusterSudoRequest to merge into", requestId)
+		}
+		existing.Status.Extensions = append(existing.Status.Extensions, v1.ExtensionRecord{
+			Duration:   extension.String(),
+			State:      "Granted",
+			Timestamp:  metav1.Now(),
+			ExpiresAt:  extendedAt.DeepCopy(),
+			MergedFrom: clusterSudoRequest.Name,
+		})
+		if err := r.Status().Update(ctx, &existing); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update the status of the ClusterSudoRequest merged into", requestId, "target", target)
+			return ctrl.Result{}, err
+		}
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("Duplicate ClusterSudoRequest '%s' was merged, extending the grant by %s until %s", clusterSudoRequest.Name, extension, existing.Status.ExpiresAt.Format(time.RFC3339)), existing.Status.RequestID)
+		r.Recorder.Event(&existing, "Normal", "Merged", eventMessage)
+	}
+
+	clusterSudoRequest.Status.State = "Merged"
+	clusterSudoRequest.Status.MergedInto = target
+	clusterSudoRequest.Status.ExpiresAt = existing.Status.ExpiresAt.DeepCopy()
+	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status to Merged", requestId)
+		return ctrl.Result{}, err
+	}
+	eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' was merged into active ClusterSudoRequest '%s', which expires at %s", clusterSudoRequest.Spec.Requester.Username, target, existing.Status.ExpiresAt.Format(time.RFC3339)), requestId)
+	r.Recorder.Event(clusterSudoRequest, "Normal", "Merged", eventMessage)
+	return ctrl.Result{}, nil
+}
+
+// queueRequest places a ClusterSudoRequest over the concurrency limits of its policy in the queue of the policy, or
+// records its new position. Queued requests are reconciled again whenever a grant of the policy changes.
+func (r *ClusterSudoRequestReconciler) queueRequest(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, position int, reason string, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if clusterSudoRequest.Status.State == "Queued" && clusterSudoRequest.Status.QueuePosition == position {
+		utils.LogInfoUID(logger, "ClusterSudoRequest is still queued", requestId, "position", position, "reason", reason)
+		return ctrl.Result{}, nil
+	}
+	queued := clusterSudoRequest.Status.State != "Queued"
+	clusterSudoRequest.Status.State = "Queued"
+	clusterSudoRequest.Status.QueuePosition = position
+	if clusterSudoRequest.Status.QueuedAt == nil {
+		clusterSudoRequest.Status.QueuedAt = &metav1.Time{Time: time.Now()}
+	}
+	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status to Queued", requestId)
+		return ctrl.Result{}, err
+	}
+	if queued {
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' was queued at position %d: %s", clusterSudoRequest.Spec.Requester.Username, position, reason), requestId)
+		r.Recorder.Event(clusterSudoRequest, "Normal", "Queued", eventMessage)
+	}
+	utils.LogInfoUID(logger, "ClusterSudoRequest is queued", requestId, "position", position, "reason", reason)
+	return ctrl.Result{}, nil
+}
+
+// markBreakGlass labels a granted break-glass ClusterSudoRequest as awaiting review and raises a warning event and a
+// notification, so that the use of break-glass access never goes unnoticed. Requests are only labelled once, so
+// the notification is not sent again when a later reconcile marks the request.
+func (r *ClusterSudoRequestReconciler) markBreakGlass(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, policyNames []string, requester string, requestId string) error {
+	logger := log.FromContext(ctx)
+
+	if clusterSudoRequest.Labels[utils.PendingReviewLabel] == "true" {
+		return nil
+	}
+	if clusterSudoRequest.Labels == nil {
+		clusterSudoRequest.Labels = make(map[string]string)
+	}
+	clusterSudoRequest.Labels[utils.PendingReviewLabel] = "true"
+	if err := r.Update(ctx, clusterSudoRequest); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("User '%s' broke the glass of %s for %s: %s", requester, utils.DescribePolicies(policyNames), clusterSudoRequest.Spec.Duration, clusterSudoRequest.Spec.Justification)
+	r.Recorder.Event(clusterSudoRequest, "Warning", "BreakGlass", utils.FormatEventMessage(message, requestId))
+	utils.LogInfoUID(logger, "Break-glass ClusterSudoRequest granted, awaiting review", requestId, "justification", clusterSudoRequest.Spec.Justification)
+
+	if err := r.Notifier.Notify(ctx, utils.Notification{
+		Severity:      "critical",
+		Reason:        "BreakGlass",
+		Kind:          "ClusterSudoRequest",
+		Name:          clusterSudoRequest.Name,
+		Namespace:     clusterSudoRequest.Namespace,
+		RequestID:     requestId,
+		Requester:     requester,
+		Policy:        strings.Join(policyNames, ","),
+		Justification: clusterSudoRequest.Spec.Justification,
+		Message:       message,
+	}); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to send break-glass notification", requestId)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("Break-glass notification could not be sent: %v", err), requestId)
+		r.Recorder.Event(clusterSudoRequest, "Warning", "NotificationFailed", eventMessage)
+	}
+	return nil
+}
+
+// processReview accepts the first review of a break-glass ClusterSudoRequest given by a reviewer of the policy, or of
+// every policy of a bundle, which ends its awaiting review condition. Reviews by anyone else are ignored.
+func (r *ClusterSudoRequestReconciler) processReview(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, requestId string) error {
+	logger := log.FromContext(ctx)
+
+	var policySpecs []v1.SudoPolicySpec
+	for _, policyName := range utils.RequestPolicies(&clusterSudoRequest.Spec, &clusterSudoRequest.Status) {
+		var clusterSudoPolicy v1.ClusterSudoPolicy
+		if err := r.Get(ctx, client.ObjectKey{Name: policyName}, &clusterSudoPolicy); err != nil {
+			return err
+		}
+		policySpecs = append(policySpecs, clusterSudoPolicy.Spec)
+	}
+	review := utils.EligibleBundleReview(policySpecs, clusterSudoRequest.Spec.Reviews, clusterSudoRequest.Spec.Requester.Username)
+	if review == nil {
+		utils.LogInfoUID(logger, "No review by a reviewer of the policy yet", requestId)
+		return nil
+	}
+
+	clusterSudoRequest.Status.ReviewState = "Reviewed"
+	clusterSudoRequest.Status.Review = &v1.Review{}
+	review.DeepCopyInto(clusterSudoRequest.Status.Review)
+	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
+		return err
+	}
+	delete(clusterSudoRequest.Labels, utils.PendingReviewLabel)
+	if err := r.Update(ctx, clusterSudoRequest); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Break-glass ClusterSudoRequest of user '%s' was reviewed by '%s'", clusterSudoRequest.Spec.Requester.Username, clusterSudoRequest.Status.Review.Reviewer.Username)
+	if comment := clusterSudoRequest.Status.Review.Comment; comment != "" {
+		message = fmt.Sprintf("%s: %s", message, comment)
+	}
+	r.Recorder.Event(clusterSudoRequest, "Normal", "Reviewed", utils.FormatEventMessage(message, requestId))
+	return nil
+}
+
+// revokeRequest ends a revoked ClusterSudoRequest early: its child resources are marked as revoked, so that their
+// bindings are cleaned up right away, and the request moves to the terminal Revoked state.
+func (r *ClusterSudoRequestReconciler) revokeRequest(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, revocation *v1.Revocation, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	for _, child := range clusterSudoRequest.Status.ChildResource {
+		switch child.Kind {
+		case "TemporaryRBAC":
+			var temporaryRBAC v1.TemporaryRBAC
+			if err := r.Get(ctx, client.ObjectKey{Name: child.Name, Namespace: child.Namespace}, &temporaryRBAC); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return ctrl.Result{}, err
+			}
+			temporaryRBAC.Spec.Revoked = true
+			if err := r.Update(ctx, &temporaryRBAC); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to revoke TemporaryRBAC", requestId, "child", child)
+				return ctrl.Result{}, err
+			}
+		case "ClusterTemporaryRBAC":
+			var clusterTemporaryRBAC v1.ClusterTemporaryRBAC
+			if err := r.Get(ctx, client.ObjectKey{Name: child.Name}, &clusterTemporaryRBAC); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return ctrl.Result{}, err
+			}
+			clusterTemporaryRBAC.Spec.Revoked = true
+			if err := r.Update(ctx, &clusterTemporaryRBAC); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to revoke ClusterTemporaryRBAC", requestId, "child", child)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	clusterSudoRequest.Status.State = "Revoked"
+	clusterSudoRequest.Status.Revocation = &v1.Revocation{}
+	revocation.DeepCopyInto(clusterSudoRequest.Status.Revocation)
+	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status to Revoked", requestId)
+		return ctrl.Result{}, err
+	}
+
+	revokedBy := fmt.Sprintf("'%s'", revocation.RevokedBy.Username)
+	if revocation.Lockdown != "" {
+		revokedBy = fmt.Sprintf("lockdown '%s'", revocation.Lockdown)
+	}
+	message := fmt.Sprintf("ClusterSudoRequest of user '%s' was revoked by %s", clusterSudoRequest.Spec.Requester.Username, revokedBy)
+	if revocation.Reason != "" {
+		message = fmt.Sprintf("%s: %s", message, revocation.Reason)
+	}
+	r.Recorder.Event(clusterSudoRequest, "Normal", "Revoked", utils.FormatEventMessage(message, requestId))
+	utils.LogInfoUID(logger, "ClusterSudoRequest was revoked", requestId, "revokedBy", revocation.RevokedBy.Username, "lockdown", revocation.Lockdown, "reason", revocation.Reason)
+	return ctrl.Result{}, nil
+}
+
+// grantAccess creates the children granting the access of every policy of an approved ClusterSudoRequest: a
+// ClusterTemporaryRBAC for a policy granting the whole cluster, a TemporaryRBAC per namespace otherwise. All of them
+// share one duration, and the request moves to Approved once they are recorded in its status.
+func (r *ClusterSudoRequestReconciler) grantAccess(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicies []v1.ClusterSudoPolicy, namespaces [][]string, requester string, logger logr.Logger, requestId string) (ctrl.Result, error) {
+	var childResources []v1.ChildResource
+	duration := r.grantDuration(clusterSudoRequest, clusterSudoPolicies, requestId)
+
+	// Resolve the roles of every policy before any access is granted
+	grants := make([]utils.PolicyGrant, len(clusterSudoPolicies))
+	for i := range clusterSudoPolicies {
+		grant, err := utils.ResolveGrant(ctx, r.Client, clusterSudoPolicies[i].Spec)
+		if err != nil {
+			return r.errorRequest(ctx, err, clusterSudoRequest, fmt.Sprintf("Failed to resolve the roles of policy '%s': %v", clusterSudoPolicies[i].Name, err), requestId)
+		}
+		grants[i] = grant
+	}
+
+	for i := range clusterSudoPolicies {
+		if len(namespaces[i]) == 1 && namespaces[i][0] == "*" {
+			grant, err := utils.RestrictGrant(ctx, r.Client, grants[i].Narrow(clusterSudoRequest.Spec.Rules), clusterSudoRequest.Spec.ResourceNames, "*")
+			if err != nil {
+				r.deleteChildResources(ctx, childResources, requestId)
+				return r.errorRequest(ctx, err, clusterSudoRequest, fmt.Sprintf("Failed to restrict the grant of policy '%s' to the requested resource names: %v", clusterSudoPolicies[i].Name, err), requestId)
+			}
+			childResource, err := r.createClusterTemporaryRBAC(ctx, clusterSudoRequest, &clusterSudoPolicies[i], grant, duration, logger, requestId)
+			if err != nil {
+				r.deleteChildResources(ctx, childResources, requestId)
+				return ctrl.Result{}, err
+			}
+			childResources = append(childResources, childResource)
+			continue
+		}
+		created, err := r.createTemporaryRBACsForNamespaces(ctx, clusterSudoRequest, namespaces[i], &clusterSudoPolicies[i], grants[i], duration, requester, logger, requestId)
+		childResources = append(childResources, created...)
+		if err != nil {
+			// A bundle is granted all at once, so the resources already created are removed and the request is retried
+			r.deleteChildResources(ctx, childResources, requestId)
+			return ctrl.Result{}, err
+		}
+	}
+
+	clusterSudoRequest.Status.State = "Approved"
+	clusterSudoRequest.Status.ChildResource = childResources
+
+	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status with child resource details", requestId)
+		return ctrl.Result{}, err
+	}
+	utils.LogInfoUID(logger, "Successfully updated ClusterSudoRequest status with child resource details, waiting for child resource creation", requestId)
+	return ctrl.Result{}, nil
+}
+
+// createTemporaryRBACsForNamespaces creates the TemporaryRBACs of a policy in its namespaces. It stops at the first
+// failure and returns the resources created so far together with the error.
+func (r *ClusterSudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, namespaces []string, clusterSudoPolicy *v1.ClusterSudoPolicy, policyGrant utils.PolicyGrant, duration string, requester string, logger logr.Logger, requestId string) ([]v1.ChildResource, error) {
 	var childResources []v1.ChildResource
 
 	for _, namespace := range namespaces {
+		grant, ok := policyGrant.InNamespace(namespace)
+		if !ok {
+			utils.LogInfoUID(logger, "Access profile grants no role in namespace, skipping", requestId, "policy", clusterSudoPolicy.Name, "namespace", namespace)
+			continue
+		}
+		grant, err := utils.RestrictGrant(ctx, r.Client, grant.Narrow(clusterSudoRequest.Spec.Rules), clusterSudoRequest.Spec.ResourceNames, namespace)
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to restrict the grant to the requested resource names", requestId, "policy", clusterSudoPolicy.Name, "namespace", namespace)
+			return childResources, err
+		}
 		temporaryRBAC := &v1.TemporaryRBAC{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      utils.GenerateTempRBACName(rbacv1.Subject{Kind: "User", Name: requester}, clusterSudoRequest.Spec.Policy, clusterSudoRequest.Status.RequestID), // fmt.Sprintf("temporaryrbac-%s-%s", clusterSudoRequest.Name, namespace),
+				Name:      utils.GenerateTempRBACName(rbacv1.Subject{Kind: "User", Name: requester}, clusterSudoPolicy.Name, clusterSudoRequest.Status.RequestID), // fmt.Sprintf("temporaryrbac-%s-%s", clusterSudoRequest.Name, namespace),
 				Namespace: namespace,
+				Labels: map[string]string{
+					"tarbac.io/policy":      clusterSudoPolicy.Name,
+					"tarbac.io/policy-kind": "ClusterSudoPolicy",
+				},
+				Annotations: utils.ReferenceAnnotations(clusterSudoRequest.Spec.Justification, clusterSudoRequest.Spec.Ticket),
 			},
 			Spec: v1.TemporaryRBACSpec{
 				Subjects: []rbacv1.Subject{
@@ -300,19 +795,23 @@ func (r *ClusterSudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx con
 						Name: requester,
 					},
 				},
-				RoleRef:  clusterSudoPolicy.Spec.RoleRef,
-				Duration: clusterSudoRequest.Spec.Duration,
+				RoleRef:   grant.RoleRef,
+				Roles:     grant.Roles,
+				Rules:     grant.Rules,
+				Duration:  duration,
+				StartTime: clusterSudoRequest.Spec.StartTime,
 			},
 		}
 
 		if err := controllerutil.SetControllerReference(clusterSudoRequest, temporaryRBAC, r.Scheme); err != nil {
 			utils.LogErrorUID(logger, err, "Failed to set OwnerReference on TemporaryRBAC", requestId)
-			continue
+			return childResources, err
 		}
 
-		if err := r.Client.Create(ctx, temporaryRBAC); err != nil {
+		// A TemporaryRBAC may already exist when the status update failed after it was created
+		if err := r.Client.Create(ctx, temporaryRBAC); err != nil && !apierrors.IsAlreadyExists(err) {
 			utils.LogErrorUID(logger, err, "Failed to create TemporaryRBAC", requestId)
-			continue
+			return childResources, err
 		}
 
 		utils.LogInfoUID(logger, "TemporaryRBAC created successfully", requestId, "temporaryRBAC", temporaryRBAC.Name, "namespace", temporaryRBAC.Namespace)
@@ -324,24 +823,33 @@ func (r *ClusterSudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx con
 			Namespace:  namespace,
 		})
 	}
+	return childResources, nil
+}
 
-	clusterSudoRequest.Status.State = "Approved"
-	clusterSudoRequest.Status.ChildResource = childResources
-
-	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
-		utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status with TemporaryRBAC details", requestId)
-		return ctrl.Result{}, err
+// deleteChildResources removes the ClusterTemporaryRBACs and TemporaryRBACs created for a grant that could not be completed.
+func (r *ClusterSudoRequestReconciler) deleteChildResources(ctx context.Context, childResources []v1.ChildResource, requestId string) {
+	logger := log.FromContext(ctx)
+	for _, childResource := range childResources {
+		var child client.Object = &v1.TemporaryRBAC{ObjectMeta: metav1.ObjectMeta{Name: childResource.Name, Namespace: childResource.Namespace}}
+		if childResource.Kind == "ClusterTemporaryRBAC" {
+			child = &v1.ClusterTemporaryRBAC{ObjectMeta: metav1.ObjectMeta{Name: childResource.Name}}
+		}
+		if err := r.Delete(ctx, child); err != nil && !apierrors.IsNotFound(err) {
+			utils.LogErrorUID(logger, err, "Failed to delete child resource of incomplete grant", requestId, "kind", childResource.Kind, "name", childResource.Name, "namespace", childResource.Namespace)
+		}
 	}
-	utils.LogInfoUID(logger, "Successfully updated ClusterSudoRequest status with TemporaryRBAC details, requeuing while waiting for child resource creation", requestId)
-	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 }
 
-func (r *ClusterSudoRequestReconciler) createClusterTemporaryRBAC(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicy *v1.ClusterSudoPolicy, logger logr.Logger, requestID string) (ctrl.Result, error) {
-	var childResources []v1.ChildResource
-	var requester = clusterSudoRequest.Annotations["tarbac.io/requester"]
+func (r *ClusterSudoRequestReconciler) createClusterTemporaryRBAC(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicy *v1.ClusterSudoPolicy, grant utils.PolicyGrant, duration string, logger logr.Logger, requestID string) (v1.ChildResource, error) {
+	var requester = clusterSudoRequest.Spec.Requester.Username
 	clusterTemporaryRBAC := &v1.ClusterTemporaryRBAC{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: utils.GenerateTempRBACName(rbacv1.Subject{Kind: "User", Name: requester}, clusterSudoRequest.Spec.Policy, clusterSudoRequest.Status.RequestID), //fmt.Sprintf("cluster-temporaryrbac-%s", clusterSudoRequest.Name),
+			Name: utils.GenerateTempRBACName(rbacv1.Subject{Kind: "User", Name: requester}, clusterSudoPolicy.Name, clusterSudoRequest.Status.RequestID), //fmt.Sprintf("cluster-temporaryrbac-%s", clusterSudoRequest.Name),
+			Labels: map[string]string{
+				"tarbac.io/policy":      clusterSudoPolicy.Name,
+				"tarbac.io/policy-kind": "ClusterSudoPolicy",
+			},
+			Annotations: utils.ReferenceAnnotations(clusterSudoRequest.Spec.Justification, clusterSudoRequest.Spec.Ticket),
 		},
 		Spec: v1.TemporaryRBACSpec{
 			Subjects: []rbacv1.Subject{
@@ -350,47 +858,293 @@ func (r *ClusterSudoRequestReconciler) createClusterTemporaryRBAC(ctx context.Co
 					Name: requester,
 				},
 			},
-			RoleRef:  clusterSudoPolicy.Spec.RoleRef,
-			Duration: clusterSudoRequest.Spec.Duration,
+			RoleRef:   grant.RoleRef,
+			Roles:     grant.Roles,
+			Rules:     grant.Rules,
+			Duration:  duration,
+			StartTime: clusterSudoRequest.Spec.StartTime,
 		},
 	}
 
 	if err := controllerutil.SetControllerReference(clusterSudoRequest, clusterTemporaryRBAC, r.Scheme); err != nil {
 		utils.LogErrorUID(logger, err, "Failed to set OwnerReference on ClusterTemporaryRBAC", requestID)
-		return ctrl.Result{}, err
+		return v1.ChildResource{}, err
 	}
 
-	if err := r.Create(ctx, clusterTemporaryRBAC); err != nil {
+	// A ClusterTemporaryRBAC may already exist when the status update of a bundle failed after it was created
+	if err := r.Create(ctx, clusterTemporaryRBAC); err != nil && !apierrors.IsAlreadyExists(err) {
 		utils.LogErrorUID(logger, err, "Failed to create ClusterTemporaryRBAC", requestID)
-		return ctrl.Result{}, err
+		return v1.ChildResource{}, err
 	}
 
 	utils.LogInfoUID(logger, "TemporaryRBAC created successfully", requestID, "ClusterTemporaryRBAC", clusterTemporaryRBAC.Name)
 
-	childResources = append(childResources, v1.ChildResource{
+	return v1.ChildResource{
 		APIVersion: "tarbac.io/v1",
 		Kind:       "ClusterTemporaryRBAC",
 		Name:       clusterTemporaryRBAC.Name,
-	})
+	}, nil
+}
 
-	clusterSudoRequest.Status.State = "Approved"
-	clusterSudoRequest.Status.ChildResource = childResources
+// checkPolicy checks a pending or queued ClusterSudoRequest against one of its policies and returns the namespaces
+// the policy grants it, or reports that it does not. Then the request has been rejected, queued or put on hold,
+// and the returned result ends the reconciliation. Each policy of a bundle is checked on its own and its
+// rejections name it.
+func (r *ClusterSudoRequestReconciler) checkPolicy(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicy *v1.ClusterSudoPolicy, bundle bool, duration time.Duration, requestId string) ([]string, bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	requester := clusterSudoRequest.Spec.Requester.Username
 
-	if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
-		utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status with ClusterTemporaryRBAC details", requestID)
-		return ctrl.Result{}, err
+	reject := func(message string) ([]string, bool, ctrl.Result, error) {
+		if bundle {
+			message = fmt.Sprintf("Policy '%s': %s", clusterSudoPolicy.Name, message)
+		}
+		result, err := r.rejectRequest(ctx, clusterSudoRequest, message, logger, requestId)
+		return nil, false, result, err
+	}
+	fail := func(err error, message string) ([]string, bool, ctrl.Result, error) {
+		if bundle {
+			message = fmt.Sprintf("Policy '%s': %s", clusterSudoPolicy.Name, message)
+		}
+		result, err := r.errorRequest(ctx, err, clusterSudoRequest, message, requestId)
+		return nil, false, result, err
+	}
+
+	maxDuration, err := time.ParseDuration(clusterSudoPolicy.Spec.MaxDuration)
+	if err != nil || duration > maxDuration {
+		return reject(fmt.Sprintf("Requested duration %s exceeds max allowed duration %s", duration, maxDuration))
+	}
+
+	if err := utils.CheckReferences(clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Justification, clusterSudoRequest.Spec.Ticket); err != nil {
+		return reject(fmt.Sprintf("Request does not satisfy the requirements of the policy: %v", err))
+	}
+
+	// Limit how often and for how long the requester is granted access, break-glass requests are exempt
+	if utils.HasQuotas(clusterSudoPolicy.Spec) && !clusterSudoRequest.Spec.BreakGlass {
+		grants, err := utils.ClusterSudoRequestGrants(ctx, r.Client, clusterSudoPolicy.Name, requester, clusterSudoRequest.Name)
+		if err != nil {
+			return fail(err, "Failed to list previous requests for the quota check")
+		}
+		reason, err := utils.CheckQuotas(clusterSudoPolicy.Spec, grants, duration, utils.ActivationTime(clusterSudoRequest.Spec.StartTime, time.Now()))
+		if err != nil {
+			return fail(err, "Invalid quotas in ClusterSudoPolicy spec")
+		}
+		if reason != "" {
+			return reject(fmt.Sprintf("Request exceeds the quotas of the policy: %s", reason))
+		}
+	}
+
+	// Break-glass requests must be allowed and justified, and are limited by the requests awaiting review
+	if clusterSudoRequest.Spec.BreakGlass {
+		reason, err := utils.CheckBreakGlass(ctx, r.Client, clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Justification, requester)
+		if err != nil {
+			return fail(err, "Failed to check break-glass requests awaiting review")
+		}
+		if reason != "" {
+			return reject(reason)
+		}
+	}
+
+	// The schedule of the policy applies to when the access starts, not when it is requested
+	activatesAt := utils.ActivationTime(clusterSudoRequest.Spec.StartTime, time.Now())
+	schedule, err := utils.EvaluateSchedule(clusterSudoPolicy.Spec.Schedule, activatesAt)
+	if err != nil {
+		return fail(err, "Invalid schedule in policy spec")
+	}
+	if !schedule.Allowed && !clusterSudoRequest.Spec.BreakGlass {
+		return reject(fmt.Sprintf("Request is outside the schedule of the policy: %s", schedule.Reason))
+	}
+
+	if !r.validateRequester(*clusterSudoPolicy, clusterSudoRequest.Spec.Requester) {
+		return reject("User not allowed by policy")
+	}
+
+	if err := utils.CheckRequiredAttributes(clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Requester); err != nil {
+		return reject(fmt.Sprintf("User does not satisfy the attribute requirements of the policy: %v", err))
+	}
+
+	namespaces, err := r.getAllowedNamespaces(clusterSudoPolicy)
+	if err != nil {
+		utils.LogErrorUID(logger, err, "Failed to retrieve allowed namespaces", requestId)
+		return nil, false, ctrl.Result{}, err
+	}
+
+	// Narrow the grant down to the namespaces requested, which must all be allowed by the policy
+	if requested := clusterSudoRequest.Spec.Namespaces; len(requested) > 0 {
+		if !utils.CoversNamespaces(namespaces, requested) {
+			return reject(fmt.Sprintf("Requested namespaces %v are not all allowed by the policy", requested))
+		}
+		namespaces = requested
+	}
+
+	// Requests for a subset of the permissions of the policy are only granted what the policy grants
+	if len(clusterSudoRequest.Spec.Rules) > 0 {
+		reason, err := utils.CheckRequestedRules(ctx, r.Client, clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Rules, namespaces)
+		if err != nil {
+			return fail(err, "Failed to resolve the permissions of the policy")
+		}
+		if reason != "" {
+			return reject(fmt.Sprintf("Requested rules are not covered by the policy: %s", reason))
+		}
+	}
+
+	// Requests restricted to some objects may only name objects the policy allows and grants access to
+	if len(clusterSudoRequest.Spec.ResourceNames) > 0 {
+		reason, err := utils.CheckResourceNames(ctx, r.Client, clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Rules, clusterSudoRequest.Spec.ResourceNames, namespaces)
+		if err != nil {
+			return fail(err, "Failed to resolve the permissions of the policy")
+		}
+		if reason != "" {
+			return reject(fmt.Sprintf("Requested resource names are not granted by the policy: %s", reason))
+		}
 	}
-	utils.LogInfoUID(logger, "Successfully updated ClusterSudoRequest status with TemporaryRBAC details, requeuing while waiting for child resource creation", requestID)
-	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+
+	if err := utils.EvaluateConditions(clusterSudoPolicy.Spec, utils.ConditionInput{
+		Name:       clusterSudoRequest.Name,
+		Policy:     clusterSudoPolicy.Name,
+		Duration:   duration,
+		Requester:  clusterSudoRequest.Spec.Requester,
+		Namespaces: namespaces,
+		Now:        time.Now(),
+	}); err != nil {
+		return reject(fmt.Sprintf("Request does not satisfy the conditions of the policy: %v", err))
+	}
+
+	if r.DecisionClient != nil {
+		decision, err := r.DecisionClient.Decide(ctx, utils.DecisionInput{
+			Kind:           "ClusterSudoRequest",
+			Name:           clusterSudoRequest.Name,
+			Policy:         clusterSudoPolicy.Name,
+			Duration:       clusterSudoRequest.Spec.Duration,
+			Requester:      clusterSudoRequest.Spec.Requester,
+			RoleRef:        clusterSudoPolicy.Spec.RoleRef,
+			AccessProfile:  clusterSudoPolicy.Spec.AccessProfile,
+			Rules:          clusterSudoPolicy.Spec.Rules,
+			RequestedRules: clusterSudoRequest.Spec.Rules,
+			ResourceNames:  clusterSudoRequest.Spec.ResourceNames,
+			Namespaces:     namespaces,
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to query the external decision point", requestId, "allow", decision.Allow)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("External decision point could not be queried: %v", err), requestId)
+			r.Recorder.Event(clusterSudoRequest, "Warning", "DecisionPointUnavailable", eventMessage)
+			// Failing closed holds the request until the decision point answers, only an explicit deny rejects it
+			if !decision.Allow {
+				return nil, false, ctrl.Result{}, err
+			}
+		}
+		if !decision.Allow {
+			return reject(fmt.Sprintf("Request denied by the external decision point: %s", decision.Reason))
+		}
+	}
+
+	// Confirm that the ticket exists and is open, the request is retried while the validator is unavailable
+	if r.TicketValidator != nil && clusterSudoRequest.Spec.Ticket != "" {
+		result, err := r.TicketValidator.Validate(ctx, utils.TicketInput{
+			Ticket:    clusterSudoRequest.Spec.Ticket,
+			Kind:      "ClusterSudoRequest",
+			Name:      clusterSudoRequest.Name,
+			Policy:    clusterSudoPolicy.Name,
+			Requester: requester,
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to validate ticket", requestId, "ticket", clusterSudoRequest.Spec.Ticket)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Ticket '%s' could not be validated: %v", clusterSudoRequest.Spec.Ticket, err), requestId)
+			r.Recorder.Event(clusterSudoRequest, "Warning", "TicketValidatorUnavailable", eventMessage)
+			return nil, false, ctrl.Result{}, err
+		}
+		if !result.Valid {
+			return reject(fmt.Sprintf("Ticket '%s' was rejected by the ticket validator: %s", clusterSudoRequest.Spec.Ticket, result.Reason))
+		}
+	}
+
+	// Hold the request while an emergency lockdown covers it, it is reconciled again once the lockdown is lifted
+	lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{Namespaces: namespaces, Policy: clusterSudoPolicy.Name, PolicyKind: "ClusterSudoPolicy", Users: []string{requester}})
+	if err != nil {
+		utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+		return nil, false, ctrl.Result{}, err
+	}
+	if lockdown != nil {
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' is on hold while lockdown '%s' is in place: %s", requester, lockdown.Name, lockdown.Spec.Reason), requestId)
+		r.Recorder.Event(clusterSudoRequest, "Warning", "LockedDown", eventMessage)
+		return nil, false, ctrl.Result{}, nil
+	}
+
+	if clusterSudoPolicy.Spec.ApprovalMode == "Manual" && !clusterSudoRequest.Spec.BreakGlass {
+		approved, result, err := r.waitForApprovals(ctx, clusterSudoRequest, clusterSudoPolicy, requester, requestId)
+		if !approved {
+			return nil, false, result, err
+		}
+	}
+
+	if len(namespaces) == 0 {
+		return reject("No namespaces matched policy constraints")
+	}
+
+	// Enforce the concurrency limits of the policy and handle duplicates of active grants, break-glass requests are exempt
+	if utils.HasConcurrencyLimits(clusterSudoPolicy.Spec) && !clusterSudoRequest.Spec.BreakGlass {
+		active, err := utils.ActiveClusterSudoRequests(ctx, r.Client, clusterSudoPolicy.Name, clusterSudoRequest.Name)
+		if err != nil {
+			return fail(err, "Failed to list active requests for the concurrency check")
+		}
+		if duplicate := utils.FindDuplicate(active, requester, utils.ActivationTime(clusterSudoRequest.Spec.StartTime, time.Now())); duplicate != nil {
+			switch clusterSudoPolicy.Spec.OnDuplicate {
+			case "Reject":
+				return reject(fmt.Sprintf("Duplicate of active ClusterSudoRequest '%s'", duplicate.Name))
+			case "Merge":
+				// The policies of a bundle are granted together, so a bundle is never merged into another grant
+				if !bundle {
+					result, err := r.mergeRequest(ctx, clusterSudoRequest, duplicate.Name, clusterSudoPolicy, duration, requestId)
+					return nil, false, result, err
+				}
+			}
+		}
+		// Requests waiting in the queue of the policy are granted first
+		queued, err := utils.QueuedClusterSudoRequests(ctx, r.Client, clusterSudoPolicy.Name)
+		if err != nil {
+			return fail(err, "Failed to list queued requests for the concurrency check")
+		}
+		ahead := utils.RequestsAhead(queued, clusterSudoRequest.Name)
+		if reason := utils.CheckConcurrency(clusterSudoPolicy.Spec, append(active, ahead...), requester); reason != "" {
+			if clusterSudoPolicy.Spec.OnLimitReached == "Queue" {
+				result, err := r.queueRequest(ctx, clusterSudoRequest, len(ahead)+1, reason, requestId)
+				return nil, false, result, err
+			}
+			return reject(fmt.Sprintf("Request exceeds the concurrency limits of the policy: %s", reason))
+		}
+	}
+
+	return namespaces, true, ctrl.Result{}, nil
 }
 
-func (r *ClusterSudoRequestReconciler) validateRequester(policy v1.ClusterSudoPolicy, requester string) bool {
-	for _, user := range policy.Spec.AllowedUsers {
-		if user.Name == requester {
-			return true
+// selectPolicy returns the name of the ClusterSudoPolicy that best permits the role and the namespaces requested
+// by a ClusterSudoRequest, or an empty string when none does.
+func (r *ClusterSudoRequestReconciler) selectPolicy(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, duration time.Duration) (string, error) {
+	var clusterSudoPolicies v1.ClusterSudoPolicyList
+	if err := r.List(ctx, &clusterSudoPolicies); err != nil {
+		return "", err
+	}
+	var candidates []utils.PolicyCandidate
+	for _, clusterSudoPolicy := range clusterSudoPolicies.Items {
+		if clusterSudoPolicy.Status.State == "Error" {
+			continue
 		}
+		candidates = append(candidates, utils.PolicyCandidate{Name: clusterSudoPolicy.Name, Spec: clusterSudoPolicy.Spec, Namespaces: clusterSudoPolicy.Status.Namespaces})
 	}
-	return false
+	selected := utils.SelectPolicy(candidates, utils.PolicyRequest{
+		RoleRef:    *clusterSudoRequest.Spec.RoleRef,
+		Namespaces: clusterSudoRequest.Spec.Namespaces,
+		Duration:   duration,
+		Requester:  clusterSudoRequest.Spec.Requester,
+		BreakGlass: clusterSudoRequest.Spec.BreakGlass,
+	})
+	if selected == nil {
+		return "", nil
+	}
+	return selected.Name, nil
+}
+
+func (r *ClusterSudoRequestReconciler) validateRequester(policy v1.ClusterSudoPolicy, requester v1.UserIdentity) bool {
+	return utils.IsAllowedRequester(policy.Spec, requester)
 }
 
 func (r *ClusterSudoRequestReconciler) errorRequest(ctx context.Context, err error, clusterSudoRequest *v1.ClusterSudoRequest, message string, requestID string) (ctrl.Result, error) {
@@ -409,6 +1163,75 @@ func (r *ClusterSudoRequestReconciler) errorRequest(ctx context.Context, err err
 	return ctrl.Result{}, nil
 }
 
+// waitForApprovals records the approvals of a manually approved request and reports whether its quorum is met.
+// Until then, further escalation tiers are announced as they become eligible and the request is rejected
+// once it has been pending for longer than the policy's pendingTTL.
+func (r *ClusterSudoRequestReconciler) waitForApprovals(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, clusterSudoPolicy *v1.ClusterSudoPolicy, requester string, requestId string) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	now := time.Now()
+	submittedAt := clusterSudoRequest.CreationTimestamp.Time
+
+	requiredApprovals := utils.RequiredApprovals(clusterSudoPolicy.Spec)
+	approvals := utils.EligibleApprovals(clusterSudoPolicy.Spec, clusterSudoRequest.Spec.Approvals, requester, submittedAt)
+	statusChanged := false
+	// Approvals already recorded for the other policies of a bundle are kept
+	for i, approval := range approvals {
+		if utils.HasApproval(clusterSudoRequest.Status.Approvals, approval) {
+			continue
+		}
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' was signed off by approver '%s' (%d/%d)", requester, approval.Approver.Username, i+1, requiredApprovals), requestId)
+		r.Recorder.Event(clusterSudoRequest, "Normal", "ApprovalGranted", eventMessage)
+		clusterSudoRequest.Status.Approvals = append(clusterSudoRequest.Status.Approvals, approval)
+		statusChanged = true
+	}
+	if len(approvals) >= requiredApprovals {
+		return true, ctrl.Result{}, nil
+	}
+
+	// Reject the request if it was not approved in time
+	var deadline time.Time
+	if clusterSudoPolicy.Spec.PendingTTL != "" {
+		pendingTTL, err := time.ParseDuration(clusterSudoPolicy.Spec.PendingTTL)
+		if err != nil {
+			result, err := r.errorRequest(ctx, err, clusterSudoRequest, "Invalid pendingTTL in ClusterSudoPolicy spec", requestId)
+			return false, result, err
+		}
+		deadline = submittedAt.Add(pendingTTL)
+		if !now.Before(deadline) {
+			result, err := r.rejectRequest(ctx, clusterSudoRequest, fmt.Sprintf("Request was not approved within %s (%d/%d approvals)", pendingTTL, len(approvals), requiredApprovals), logger, requestId)
+			return false, result, err
+		}
+	}
+
+	// Announce escalation tiers that became eligible since the last reconciliation
+	level, nextEscalation := utils.EscalationLevel(clusterSudoPolicy.Spec, submittedAt, now)
+	for i := clusterSudoRequest.Status.EscalationLevel; i < level && i < len(clusterSudoPolicy.Spec.Escalations); i++ {
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("ClusterSudoRequest of user '%s' was escalated to approvers %v after %s", requester, clusterSudoPolicy.Spec.Escalations[i].Approvers, clusterSudoPolicy.Spec.Escalations[i].After), requestId)
+		r.Recorder.Event(clusterSudoRequest, "Normal", "Escalated", eventMessage)
+	}
+	if level > clusterSudoRequest.Status.EscalationLevel {
+		clusterSudoRequest.Status.EscalationLevel = level
+		statusChanged = true
+	}
+
+	if statusChanged {
+		if err := r.Status().Update(ctx, clusterSudoRequest); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update ClusterSudoRequest status with approvals", requestId)
+			return false, ctrl.Result{}, err
+		}
+	}
+
+	// Requeue for the next escalation or the pending deadline, whichever comes first
+	var requeueAfter time.Duration
+	for _, next := range []time.Time{deadline, nextEscalation} {
+		if !next.IsZero() && (requeueAfter == 0 || time.Until(next) < requeueAfter) {
+			requeueAfter = time.Until(next)
+		}
+	}
+	utils.LogInfoUID(logger, "ClusterSudoRequest is waiting for approval", requestId, "policy", clusterSudoPolicy.Name, "approvals", len(approvals), "requiredApprovals", requiredApprovals, "requeueAfter", requeueAfter)
+	return false, ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
 func (r *ClusterSudoRequestReconciler) rejectRequest(ctx context.Context, clusterSudoRequest *v1.ClusterSudoRequest, message string, logger logr.Logger, requestID string) (ctrl.Result, error) {
 
 	utils.LogInfoUID(logger, "Rejecting ClusterSudoRequest", requestID, "errorMessage", message)
@@ -435,10 +1258,72 @@ func (r *ClusterSudoRequestReconciler) getRequestID(clusterSudoRequest *v1.Clust
 	return requestId
 }
 
+// grantedNamespaces returns the namespaces a ClusterSudoRequest granted access to, "*" for the whole cluster
+func grantedNamespaces(clusterSudoRequest *v1.ClusterSudoRequest) []string {
+	var namespaces []string
+	for _, child := range clusterSudoRequest.Status.ChildResource {
+		if child.Kind == "ClusterTemporaryRBAC" {
+			namespaces = append(namespaces, "*")
+		} else {
+			namespaces = append(namespaces, child.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// requestsForLockdown enqueues the pending, queued and approved ClusterSudoRequests when a lockdown is created, changed or lifted
+func (r *ClusterSudoRequestReconciler) requestsForLockdown(ctx context.Context, lockdown client.Object) []reconcile.Request {
+	var clusterSudoRequests v1.ClusterSudoRequestList
+	if err := r.List(ctx, &clusterSudoRequests); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list ClusterSudoRequests for lockdown", "lockdown", lockdown.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range clusterSudoRequests.Items {
+		if item.Status.State == "Pending" || item.Status.State == "Queued" || item.Status.State == "Approved" {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+		}
+	}
+	return requests
+}
+
+// queuedRequestsForGrant enqueues the ClusterSudoRequests queued under the policy of a TemporaryRBAC or
+// ClusterTemporaryRBAC when it changes, so that they are granted as soon as it expires or is revoked
+func (r *ClusterSudoRequestReconciler) queuedRequestsForGrant(ctx context.Context, grant client.Object) []reconcile.Request {
+	policy := grant.GetLabels()["tarbac.io/policy"]
+	if policy == "" {
+		return nil
+	}
+	queued, err := utils.QueuedClusterSudoRequests(ctx, r.Client, policy)
+	if err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list queued ClusterSudoRequests for grant", "grant", grant.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range queued {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+	}
+	return requests
+}
+
 func (r *ClusterSudoRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Scheme = mgr.GetScheme()
 	r.Recorder = mgr.GetEventRecorderFor("ClusterSudoRequestController")
+
+	// Index requests by requester so that quotas can count the previous requests of a user
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.ClusterSudoRequest{}, utils.RequesterIndex, utils.IndexRequester); err != nil {
+		return err
+	}
+	// Index requests by policy so that concurrency limits can count the active grants of a policy
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.ClusterSudoRequest{}, utils.PolicyIndex, utils.IndexPolicy); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.ClusterSudoRequest{}).
+		Owns(&v1.TemporaryRBAC{}).
+		Owns(&v1.ClusterTemporaryRBAC{}).
+		Watches(&v1.TemporaryRBAC{}, handler.EnqueueRequestsFromMapFunc(r.queuedRequestsForGrant)).
+		Watches(&v1.ClusterTemporaryRBAC{}, handler.EnqueueRequestsFromMapFunc(r.queuedRequestsForGrant)).
+		Watches(&v1.Lockdown{}, handler.EnqueueRequestsFromMapFunc(r.requestsForLockdown)).
 		Complete(r)
 }
@@ -11,11 +11,14 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type ClusterTemporaryRBACReconciler struct {
@@ -70,6 +73,40 @@ func (r *ClusterTemporaryRBACReconciler) Reconcile(ctx context.Context, req ctrl
 		return ctrl.Result{}, err
 	}
 
+	// Revoked grants have their bindings cleaned up right away and are never activated again
+	if clusterTempRBAC.Spec.Revoked {
+		if clusterTempRBAC.Status.State != "Revoked" {
+			utils.LogInfoUID(logger, "ClusterTemporaryRBAC was revoked, cleaning up associated bindings", requestId)
+			if err := r.cleanupBindings(ctx, &clusterTempRBAC, requestId, "Revoked"); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to clean up bindings for revoked ClusterTemporaryRBAC", requestId)
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Revoke the grant when an emergency lockdown covers it, it stays revoked once the lockdown is lifted
+	if clusterTempRBAC.Status.State != "Expired" {
+		lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{
+			Namespaces: []string{"*"},
+			Policy:     clusterTempRBAC.Labels["tarbac.io/policy"],
+			PolicyKind: clusterTempRBAC.Labels["tarbac.io/policy-kind"],
+			Users:      utils.SubjectUsers(clusterTempRBAC.Spec.Subjects),
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+			return ctrl.Result{}, err
+		}
+		if lockdown != nil {
+			return r.revokeForLockdown(ctx, &clusterTempRBAC, lockdown, requestId)
+		}
+	}
+
+	// Hold off creating any binding until the scheduled start time arrives
+	if clusterTempRBAC.Spec.StartTime != nil && currentTime.Before(clusterTempRBAC.Spec.StartTime.Time) {
+		return r.scheduleActivation(ctx, &clusterTempRBAC, duration, requestId)
+	}
+
 	if clusterTempRBAC.Status.CreatedAt == nil || isActive(clusterTempRBAC, currentTime) {
 		// Ensure bindings are created and status is updated
 		if err := r.ensureBindings(ctx, &clusterTempRBAC, requestId); err != nil {
@@ -96,7 +133,7 @@ func (r *ClusterTemporaryRBACReconciler) Reconcile(ctx context.Context, req ctrl
 		utils.LogInfoUID(logger, "ClusterTemporaryRBAC expired, cleaning up associated bindings", requestId, "currentTime", currentTime, "expiresAt", clusterTempRBAC.Status.ExpiresAt)
 
 		// Cleanup expired bindings
-		if err := r.cleanupBindings(ctx, &clusterTempRBAC, requestId); err != nil {
+		if err := r.cleanupBindings(ctx, &clusterTempRBAC, requestId, "Expired"); err != nil {
 			utils.LogErrorUID(logger, err, "Failed to clean up bindings for expired ClusterTemporaryRBAC", requestId)
 			return ctrl.Result{}, err
 		}
@@ -122,6 +159,53 @@ func isActive(clusterTempRBAC tarbacv1.ClusterTemporaryRBAC, currentTime time.Ti
 	return clusterTempRBAC.Status.ExpiresAt != nil && currentTime.Before(clusterTempRBAC.Status.ExpiresAt.Time) && currentTime.After(clusterTempRBAC.Status.CreatedAt.Time)
 }
 
+// scheduleActivation records the scheduled window of a ClusterTemporaryRBAC whose start time is in the future
+// and requeues it for when the bindings should be created.
+func (r *ClusterTemporaryRBACReconciler) scheduleActivation(ctx context.Context, clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC, duration time.Duration, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	startTime := clusterTempRBAC.Spec.StartTime.Time
+
+	if clusterTempRBAC.Status.State != "Scheduled" {
+		clusterTempRBAC.Status.CreatedAt = &metav1.Time{Time: startTime}
+		clusterTempRBAC.Status.ExpiresAt = &metav1.Time{Time: startTime.Add(duration)}
+		clusterTempRBAC.Status.State = "Scheduled"
+		if err := r.Status().Update(ctx, clusterTempRBAC); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update ClusterTemporaryRBAC status with scheduled window", requestId, "startTime", startTime)
+			return ctrl.Result{}, err
+		}
+		eventMessage := fmt.Sprintf("Temporary permissions for %s are scheduled from %s until %s", clusterTempRBAC.Name, clusterTempRBAC.Status.CreatedAt.Format(time.RFC3339), clusterTempRBAC.Status.ExpiresAt.Format(time.RFC3339))
+		r.Recorder.Event(clusterTempRBAC, "Normal", "Scheduled", utils.FormatEventMessage(eventMessage, requestId))
+	}
+
+	timeUntilStart := time.Until(startTime)
+	utils.LogInfoUID(logger, "ClusterTemporaryRBAC is scheduled, requeueing for activation", requestId, "timeUntilStart", timeUntilStart)
+	if timeUntilStart < 1*time.Second {
+		return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+	}
+	return ctrl.Result{RequeueAfter: timeUntilStart}, nil
+}
+
+// revokeForLockdown marks a ClusterTemporaryRBAC covered by a lockdown as revoked and cleans up its bindings
+func (r *ClusterTemporaryRBACReconciler) revokeForLockdown(ctx context.Context, clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC, lockdown *tarbacv1.Lockdown, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	clusterTempRBAC.Spec.Revoked = true
+	if err := r.Update(ctx, clusterTempRBAC); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to mark ClusterTemporaryRBAC as revoked", requestId, "lockdown", lockdown.Name)
+		return ctrl.Result{}, err
+	}
+
+	eventMessage := fmt.Sprintf("Temporary permissions for %s in cluster scope were revoked by lockdown '%s': %s", clusterTempRBAC.Name, lockdown.Name, lockdown.Spec.Reason)
+	r.Recorder.Event(clusterTempRBAC, "Warning", "LockedDown", utils.FormatEventMessage(eventMessage, requestId))
+	utils.LogInfoUID(logger, "ClusterTemporaryRBAC revoked by lockdown, cleaning up associated bindings", requestId, "lockdown", lockdown.Name)
+
+	if err := r.cleanupBindings(ctx, clusterTempRBAC, requestId, "Revoked"); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to clean up bindings for revoked ClusterTemporaryRBAC", requestId)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
 func (r *ClusterTemporaryRBACReconciler) getRequestID(clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC) string {
 
 	var requestId string
@@ -133,7 +217,8 @@ func (r *ClusterTemporaryRBACReconciler) getRequestID(clusterTempRBAC *tarbacv1.
 	return requestId
 }
 
-// ensureBindings creates ClusterRoleBindings for the ClusterTemporaryRBAC resource
+// ensureBindings creates ClusterRoleBindings for the ClusterTemporaryRBAC resource, one for each role and subject.
+// Roles of an access profile scoped to a namespace are bound by RoleBindings in that namespace instead.
 func (r *ClusterTemporaryRBACReconciler) ensureBindings(ctx context.Context, clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC, requestId string) error {
 	logger := log.FromContext(ctx)
 
@@ -147,6 +232,18 @@ func (r *ClusterTemporaryRBACReconciler) ensureBindings(ctx context.Context, clu
 		return fmt.Errorf("no subjects specified")
 	}
 
+	roles := utils.BindingRoles(&clusterTempRBAC.Spec)
+	for _, role := range roles {
+		if role.Kind == "Role" && role.Namespace == "" {
+			utils.LogErrorUID(logger, nil, "Role without a namespace cannot be bound in cluster scope", requestId, "role", role.Name)
+			return fmt.Errorf("role '%s' must be scoped to a namespace to be bound in cluster scope", role.Name)
+		}
+	}
+	if len(roles) == 0 && len(clusterTempRBAC.Spec.Rules) == 0 {
+		utils.LogErrorUID(logger, nil, "No roles specified in ClusterTemporaryRBAC", requestId)
+		return fmt.Errorf("no roleRef, roles or rules specified")
+	}
+
 	// Set CreatedAt if not already set
 	if clusterTempRBAC.Status.CreatedAt == nil {
 		clusterTempRBAC.Status.CreatedAt = &metav1.Time{Time: time.Now()}
@@ -154,42 +251,93 @@ func (r *ClusterTemporaryRBACReconciler) ensureBindings(ctx context.Context, clu
 
 	var childResources = []tarbacv1.ChildResource{}
 
-	for _, subject := range subjects {
-
-		roleBinding := &rbacv1.ClusterRoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: utils.GenerateBindingName(subject, clusterTempRBAC.Spec.RoleRef, requestId),
-				Labels: map[string]string{
-					"tarbac.io/owner":      clusterTempRBAC.Name,
-					"tarbac.io/request-id": requestId,
-				},
-			},
-			Subjects: []rbacv1.Subject{subject},
-			RoleRef: rbacv1.RoleRef{
-				APIGroup: clusterTempRBAC.Spec.RoleRef.APIGroup,
-				Kind:     clusterTempRBAC.Spec.RoleRef.Kind,
-				Name:     clusterTempRBAC.Spec.RoleRef.Name,
-			},
-		}
-
-		// Set the OwnerReference on the ClusterRoleBinding
-		if err := controllerutil.SetControllerReference(clusterTempRBAC, roleBinding, r.Scheme); err != nil {
-			utils.LogErrorUID(logger, err, "Failed to set OwnerReference for ClusterRoleBinding", requestId, "ClusterRoleBinding", roleBinding.Name)
+	// Inline rules are held by an ephemeral ClusterRole that lives as long as the grant
+	if len(clusterTempRBAC.Spec.Rules) > 0 {
+		clusterRole, err := r.ensureEphemeralClusterRole(ctx, clusterTempRBAC, requestId)
+		if err != nil {
 			return err
 		}
+		roles = append(roles, tarbacv1.RoleRefWithNamespace{RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole.Name}})
+		childResources = append(childResources, tarbacv1.ChildResource{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRole",
+			Name:       clusterRole.Name,
+		})
+	}
 
-		// Create the ClusterRoleBinding
-		if err := r.Client.Create(ctx, roleBinding); err != nil && !apierrors.IsAlreadyExists(err) {
-			utils.LogErrorUID(logger, err, "Failed to create ClusterRoleBinding", requestId, "ClusterRoleBinding", roleBinding.Name)
-			return err
+	for _, role := range roles {
+		roleRef := rbacv1.RoleRef{
+			APIGroup: role.APIGroup,
+			Kind:     role.Kind,
+			Name:     role.Name,
 		}
 
-		// Add to childResources with proper Kind and APIVersion
-		childResources = append(childResources, tarbacv1.ChildResource{
-			APIVersion: rbacv1.SchemeGroupVersion.String(), // Correctly set the APIVersion
-			Kind:       "ClusterRoleBinding",               // Correctly set the Kind
-			Name:       roleBinding.GetName(),
-		})
+		for _, subject := range subjects {
+			labels := map[string]string{
+				"tarbac.io/owner":      clusterTempRBAC.Name,
+				"tarbac.io/request-id": requestId,
+			}
+
+			if role.Namespace != "" {
+				roleBinding := &rbacv1.RoleBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      utils.GenerateBindingName(subject, roleRef, requestId),
+						Namespace: role.Namespace,
+						Labels:    labels,
+					},
+					Subjects: []rbacv1.Subject{subject},
+					RoleRef:  roleRef,
+				}
+
+				// Set the OwnerReference on the RoleBinding
+				if err := controllerutil.SetControllerReference(clusterTempRBAC, roleBinding, r.Scheme); err != nil {
+					utils.LogErrorUID(logger, err, "Failed to set OwnerReference for RoleBinding", requestId, "RoleBinding", roleBinding.Name, "namespace", roleBinding.Namespace)
+					return err
+				}
+
+				// Create the RoleBinding
+				if err := r.Client.Create(ctx, roleBinding); err != nil && !apierrors.IsAlreadyExists(err) {
+					utils.LogErrorUID(logger, err, "Failed to create RoleBinding", requestId, "RoleBinding", roleBinding.Name, "namespace", roleBinding.Namespace)
+					return err
+				}
+
+				childResources = append(childResources, tarbacv1.ChildResource{
+					APIVersion: rbacv1.SchemeGroupVersion.String(),
+					Kind:       "RoleBinding",
+					Name:       roleBinding.GetName(),
+					Namespace:  roleBinding.GetNamespace(),
+				})
+				continue
+			}
+
+			roleBinding := &rbacv1.ClusterRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   utils.GenerateBindingName(subject, roleRef, requestId),
+					Labels: labels,
+				},
+				Subjects: []rbacv1.Subject{subject},
+				RoleRef:  roleRef,
+			}
+
+			// Set the OwnerReference on the ClusterRoleBinding
+			if err := controllerutil.SetControllerReference(clusterTempRBAC, roleBinding, r.Scheme); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to set OwnerReference for ClusterRoleBinding", requestId, "ClusterRoleBinding", roleBinding.Name)
+				return err
+			}
+
+			// Create the ClusterRoleBinding
+			if err := r.Client.Create(ctx, roleBinding); err != nil && !apierrors.IsAlreadyExists(err) {
+				utils.LogErrorUID(logger, err, "Failed to create ClusterRoleBinding", requestId, "ClusterRoleBinding", roleBinding.Name)
+				return err
+			}
+
+			// Add to childResources with proper Kind and APIVersion
+			childResources = append(childResources, tarbacv1.ChildResource{
+				APIVersion: rbacv1.SchemeGroupVersion.String(), // Correctly set the APIVersion
+				Kind:       "ClusterRoleBinding",               // Correctly set the Kind
+				Name:       roleBinding.GetName(),
+			})
+		}
 	}
 
 	// Update the status with created child resources
@@ -209,8 +357,36 @@ func (r *ClusterTemporaryRBACReconciler) ensureBindings(ctx context.Context, clu
 	return nil
 }
 
-// cleanupBindings deletes the ClusterRoleBindings associated with the ClusterTemporaryRBAC resource
-func (r *ClusterTemporaryRBACReconciler) cleanupBindings(ctx context.Context, clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC, requestId string) error {
+// ensureEphemeralClusterRole creates the ClusterRole holding the inline rules of the ClusterTemporaryRBAC, owned by it
+func (r *ClusterTemporaryRBACReconciler) ensureEphemeralClusterRole(ctx context.Context, clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC, requestId string) (*rbacv1.ClusterRole, error) {
+	logger := log.FromContext(ctx)
+
+	clusterRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: utils.EphemeralRoleName(clusterTempRBAC.Name),
+			Labels: map[string]string{
+				"tarbac.io/owner":      clusterTempRBAC.Name,
+				"tarbac.io/request-id": requestId,
+			},
+		},
+		Rules: clusterTempRBAC.Spec.Rules,
+	}
+
+	if err := controllerutil.SetControllerReference(clusterTempRBAC, clusterRole, r.Scheme); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to set OwnerReference for ClusterRole", requestId, "ClusterRole", clusterRole.Name)
+		return nil, err
+	}
+
+	if err := r.Client.Create(ctx, clusterRole); err != nil && !apierrors.IsAlreadyExists(err) {
+		utils.LogErrorUID(logger, err, "Failed to create ephemeral ClusterRole", requestId, "ClusterRole", clusterRole.Name)
+		return nil, err
+	}
+	return clusterRole, nil
+}
+
+// cleanupBindings deletes the ClusterRoleBindings, RoleBindings and the ephemeral ClusterRole associated with the ClusterTemporaryRBAC resource
+// and moves it to the given state, Expired or Revoked, once no binding remains
+func (r *ClusterTemporaryRBACReconciler) cleanupBindings(ctx context.Context, clusterTempRBAC *tarbacv1.ClusterTemporaryRBAC, requestId string, state string) error {
 	logger := log.FromContext(ctx)
 	var remainingChildResources []tarbacv1.ChildResource
 
@@ -232,6 +408,33 @@ func (r *ClusterTemporaryRBACReconciler) cleanupBindings(ctx context.Context, cl
 			// r.Recorder.Event(clusterTempRBAC, "Normal", "PermissionsRevoked", fmt.Sprintf("Temporary permissions were revoked in cluster scope [UID: %s]", requestId))
 			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Temporary permissions were revoked in cluster scope"), requestId)
 			r.Recorder.Event(clusterTempRBAC, "Normal", "PermissionsRevoked", eventMessage)
+		} else if child.Kind == "RoleBinding" {
+			// Delete the RoleBinding of a role scoped to a namespace
+			if err := r.Client.Delete(ctx, &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      child.Name,
+					Namespace: child.Namespace,
+				},
+			}); err != nil && !apierrors.IsNotFound(err) {
+				utils.LogErrorUID(logger, err, "Failed to delete RoleBinding", requestId, "kind", child.Kind, "name", child.Name, "namespace", child.Namespace)
+				remainingChildResources = append(remainingChildResources, child)
+				continue
+			}
+			utils.LogInfoUID(logger, "Successfully deleted RoleBinding", requestId, "kind", child.Kind, "name", child.Name, "namespace", child.Namespace)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Temporary permissions were revoked in namespace %s", child.Namespace), requestId)
+			r.Recorder.Event(clusterTempRBAC, "Normal", "PermissionsRevoked", eventMessage)
+		} else if child.Kind == "ClusterRole" {
+			// Delete the ephemeral ClusterRole of inline rules
+			if err := r.Client.Delete(ctx, &rbacv1.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: child.Name,
+				},
+			}); err != nil && !apierrors.IsNotFound(err) {
+				utils.LogErrorUID(logger, err, "Failed to delete ClusterRole", requestId, "kind", child.Kind, "name", child.Name)
+				remainingChildResources = append(remainingChildResources, child)
+				continue
+			}
+			utils.LogInfoUID(logger, "Successfully deleted ClusterRole", requestId, "kind", child.Kind, "name", child.Name)
 		} else {
 			utils.LogErrorUID(logger, nil, "Unsupported child resource kind", requestId, "kind", child.Kind)
 			remainingChildResources = append(remainingChildResources, child)
@@ -247,7 +450,7 @@ func (r *ClusterTemporaryRBACReconciler) cleanupBindings(ctx context.Context, cl
 
 	// Update the state if no child resources remain
 	if clusterTempRBAC.Status.ChildResource == nil {
-		clusterTempRBAC.Status.State = "Expired"
+		clusterTempRBAC.Status.State = state
 	}
 
 	// Check RetentionPolicy
@@ -297,6 +500,9 @@ func (r *ClusterTemporaryRBACReconciler) fetchAndSetRequestID(ctx context.Contex
 					continue
 				}
 				ownerRequestID = sudoRequest.Status.RequestID
+			case "RecurringSudoRequest", "ClusterRecurringSudoRequest":
+				// Every occurrence of a recurring request is tracked under its own RequestID
+				ownerRequestID = string(tempRBAC.ObjectMeta.UID)
 			default:
 				utils.LogInfoUID(logger, "Unsupported owner reference kind, skipping", requestId, "ownerRef", ownerRef.Name, "ownerRefKind", ownerRef.Kind)
 				continue
@@ -329,9 +535,27 @@ func (r *ClusterTemporaryRBACReconciler) fetchAndSetRequestID(ctx context.Contex
 	return nil
 }
 
+// clusterTemporaryRBACsForLockdown enqueues the active ClusterTemporaryRBACs when a lockdown is created, changed or lifted
+func (r *ClusterTemporaryRBACReconciler) clusterTemporaryRBACsForLockdown(ctx context.Context, lockdown client.Object) []reconcile.Request {
+	var list tarbacv1.ClusterTemporaryRBACList
+	if err := r.List(ctx, &list); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list ClusterTemporaryRBACs for lockdown", "lockdown", lockdown.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range list.Items {
+		if item.Status.State == "Expired" || item.Status.State == "Revoked" {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name}})
+	}
+	return requests
+}
+
 func (r *ClusterTemporaryRBACReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("ClusterTemporaryRBACController")
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&tarbacv1.ClusterTemporaryRBAC{}).
+		Watches(&tarbacv1.Lockdown{}, handler.EnqueueRequestsFromMapFunc(r.clusterTemporaryRBACsForLockdown)).
 		Complete(r)
 }
@@ -8,10 +8,13 @@ import (
 	v1 "github.com/guybal/tarbac/api/v1"
 	utils "github.com/guybal/tarbac/utils"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type SudoPolicyReconciler struct {
@@ -37,12 +40,155 @@ func (r *SudoPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// Validate maxDuration
-	if _, err := time.ParseDuration(sudoPolicy.Spec.MaxDuration); err != nil {
+	maxDuration, err := time.ParseDuration(sudoPolicy.Spec.MaxDuration)
+	if err != nil {
 		return r.errorRequest(ctx, err, &sudoPolicy, fmt.Sprintf("Invalid MaxDuration in SudoPolicy spec: %s", sudoPolicy.Spec.MaxDuration))
 	}
 
+	// Validate maxTotalDuration
+	if sudoPolicy.Spec.MaxTotalDuration != "" {
+		maxTotalDuration, err := time.ParseDuration(sudoPolicy.Spec.MaxTotalDuration)
+		if err != nil {
+			return r.errorRequest(ctx, err, &sudoPolicy, fmt.Sprintf("Invalid maxTotalDuration in SudoPolicy spec: %s", sudoPolicy.Spec.MaxTotalDuration))
+		}
+		if maxTotalDuration < maxDuration {
+			errorMessage := fmt.Sprintf("maxTotalDuration (%s) must not be shorter than maxDuration (%s)", maxTotalDuration, maxDuration)
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+		}
+	}
+
+	// Validate the granted role, access profile or inline rules
+	if err := utils.ValidatePolicyRoles(sudoPolicy.Spec); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+	if err := utils.ValidateRules(sudoPolicy.Spec.Rules, false); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+	if err := utils.ValidateResourceNamePatterns(sudoPolicy.Spec.AllowedResourceNames); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+	if sudoPolicy.Spec.AccessProfile != "" {
+		profile, err := utils.GetAccessProfile(ctx, r.Client, sudoPolicy.Spec.AccessProfile)
+		if err != nil {
+			return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+		}
+		for _, role := range profile.Spec.Roles {
+			if role.Namespace != "" && role.Namespace != sudoPolicy.Namespace {
+				errorMessage := fmt.Sprintf("access profile '%s' grants %s in namespace '%s', outside of the namespace of the policy", profile.Name, utils.DescribeRoleRef(role.RoleRef), role.Namespace)
+				err := fmt.Errorf("%s", errorMessage)
+				return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+			}
+		}
+	}
+
+	// Validate allowed subjects
+	if len(sudoPolicy.Spec.AllowedUsers) == 0 && len(sudoPolicy.Spec.AllowedGroups) == 0 && len(sudoPolicy.Spec.Conditions) == 0 {
+		errorMessage := "either allowedUsers, allowedGroups or conditions must be set"
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+	}
+
+	// Validate attribute requirements
+	for _, requirement := range sudoPolicy.Spec.RequiredAttributes {
+		if err := utils.ValidateAttributeRequirement(requirement); err != nil {
+			return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+		}
+	}
+
+	// Validate conditions
+	if _, err := utils.CompileConditions(sudoPolicy.Spec.Conditions); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+
+	// Validate schedule
+	if err := utils.ValidateSchedule(sudoPolicy.Spec.Schedule); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+
+	// Validate approvers
+	if sudoPolicy.Spec.ApprovalMode == "Manual" && len(sudoPolicy.Spec.Approvers) == 0 {
+		errorMessage := "approvers must be set when approvalMode is Manual"
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+	}
+	if sudoPolicy.Spec.RequiredApprovals < 0 {
+		errorMessage := fmt.Sprintf("requiredApprovals must not be negative: %d", sudoPolicy.Spec.RequiredApprovals)
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+	}
+	if approvers := utils.AllApprovers(sudoPolicy.Spec); sudoPolicy.Spec.ApprovalMode == "Manual" && !hasGroupApprover(approvers) && utils.RequiredApprovals(sudoPolicy.Spec) > len(approvers) {
+		errorMessage := fmt.Sprintf("requiredApprovals (%d) exceeds the number of approvers (%d)", utils.RequiredApprovals(sudoPolicy.Spec), len(approvers))
+		err := fmt.Errorf("%s", errorMessage)
+		return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+	}
+
+	// Validate pending TTL and escalation tiers
+	if sudoPolicy.Spec.PendingTTL != "" {
+		if _, err := time.ParseDuration(sudoPolicy.Spec.PendingTTL); err != nil {
+			return r.errorRequest(ctx, err, &sudoPolicy, fmt.Sprintf("Invalid pendingTTL in SudoPolicy spec: %s", sudoPolicy.Spec.PendingTTL))
+		}
+	}
+	for _, tier := range sudoPolicy.Spec.Escalations {
+		if _, err := time.ParseDuration(tier.After); err != nil {
+			return r.errorRequest(ctx, err, &sudoPolicy, fmt.Sprintf("Invalid escalation delay in SudoPolicy spec: %s", tier.After))
+		}
+		if len(tier.Approvers) == 0 {
+			errorMessage := fmt.Sprintf("escalation tier after %s has no approvers", tier.After)
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+		}
+	}
+
+	// Validate justification and ticket requirements
+	if err := utils.ValidateTextRequirement("justification", sudoPolicy.Spec.Justification); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+	if err := utils.ValidateTextRequirement("ticket", sudoPolicy.Spec.Ticket); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+
+	// Validate quotas and cooldown
+	if err := utils.ValidateQuotas(sudoPolicy.Spec); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+
+	// Validate concurrency limits
+	if err := utils.ValidateConcurrencyLimits(sudoPolicy.Spec); err != nil {
+		return r.errorRequest(ctx, err, &sudoPolicy, err.Error())
+	}
+
+	// Validate break-glass
+	if sudoPolicy.Spec.BreakGlass != nil {
+		if len(utils.BreakGlassReviewers(sudoPolicy.Spec)) == 0 {
+			errorMessage := "breakGlass.reviewers or approvers must be set to review break-glass requests"
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+		}
+		if sudoPolicy.Spec.BreakGlass.MaxUnreviewed < 0 {
+			errorMessage := fmt.Sprintf("breakGlass.maxUnreviewed must not be negative: %d", sudoPolicy.Spec.BreakGlass.MaxUnreviewed)
+			err := fmt.Errorf("%s", errorMessage)
+			return r.errorRequest(ctx, err, &sudoPolicy, errorMessage)
+		}
+	}
+
+	// Report the policy as locked down while an emergency lockdown covers it
+	lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{Namespaces: []string{sudoPolicy.Namespace}, Policy: sudoPolicy.Name, PolicyKind: "SudoPolicy"})
+	if err != nil {
+		utils.LogError(logger, err, "Failed to check for lockdowns", "name", sudoPolicy.Name)
+		return ctrl.Result{}, err
+	}
+	state := "Active"
+	if lockdown != nil {
+		state = "LockedDown"
+		if sudoPolicy.Status.State != "LockedDown" {
+			eventMessage := fmt.Sprintf("Requests for policy '%s' are on hold while lockdown '%s' is in place: %s", sudoPolicy.Name, lockdown.Name, lockdown.Spec.Reason)
+			r.Recorder.Event(&sudoPolicy, "Warning", "LockedDown", eventMessage)
+		}
+	}
+
 	// Update SudoPolicy status
-	sudoPolicy.Status.State = "Active"
+	sudoPolicy.Status.State = state
 	if err := r.Status().Update(ctx, &sudoPolicy); err != nil {
 		return r.errorRequest(ctx, err, &sudoPolicy, "Failed to update SudoPolicy status")
 	}
@@ -67,9 +213,53 @@ func (r *SudoPolicyReconciler) errorRequest(ctx context.Context, err error, sudo
 	return ctrl.Result{}, nil
 }
 
+// hasGroupApprover reports whether any approver is a group, whose size is unknown to the controller
+func hasGroupApprover(approvers []v1.ApproverRef) bool {
+	for _, approver := range approvers {
+		if approver.Kind == "Group" {
+			return true
+		}
+	}
+	return false
+}
+
+// policiesForLockdown enqueues every SudoPolicy when a lockdown is created, changed or lifted
+func (r *SudoPolicyReconciler) policiesForLockdown(ctx context.Context, lockdown client.Object) []reconcile.Request {
+	var policies v1.SudoPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list SudoPolicies for lockdown", "lockdown", lockdown.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range policies.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace}})
+	}
+	return requests
+}
+
+// policiesForAccessProfile enqueues the SudoPolicies granting an access profile when it is created, changed or deleted
+func (r *SudoPolicyReconciler) policiesForAccessProfile(ctx context.Context, profile client.Object) []reconcile.Request {
+	var policies v1.SudoPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list SudoPolicies for access profile", "accessProfile", profile.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range policies.Items {
+		if item.Spec.AccessProfile != profile.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace}})
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *SudoPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("SudoPolicyController")
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.SudoPolicy{}).
+		Watches(&v1.Lockdown{}, handler.EnqueueRequestsFromMapFunc(r.policiesForLockdown)).
+		Watches(&v1.AccessProfile{}, handler.EnqueueRequestsFromMapFunc(r.policiesForAccessProfile)).
 		Complete(r)
 }
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -13,17 +14,23 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type SudoRequestReconciler struct {
 	client.Client
-	Scheme   *runtime.Scheme
-	Recorder record.EventRecorder
+	Scheme          *runtime.Scheme
+	Recorder        record.EventRecorder
+	DecisionClient  *utils.DecisionClient  // Optional external policy decision point
+	Notifier        *utils.Notifier        // Optional endpoint notified of break-glass requests
+	TicketValidator *utils.TicketValidator // Optional external validator of ticket references
 }
 
 // Reconcile handles reconciliation for SudoRequest objects
@@ -46,11 +53,24 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	requestId = r.getRequestID(&sudoRequest)
 
-	if sudoRequest.Status.State == "Rejected" || sudoRequest.Status.State == "Expired" {
+	// Break-glass requests are reviewed after the fact, whatever state they are in
+	if sudoRequest.Status.ReviewState == "AwaitingReview" && len(sudoRequest.Spec.Reviews) > 0 {
+		if err := r.processReview(ctx, &sudoRequest, requestId); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to process review of break-glass SudoRequest", requestId)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if sudoRequest.Status.State == "Rejected" || sudoRequest.Status.State == "Expired" || sudoRequest.Status.State == "Revoked" || sudoRequest.Status.State == "Merged" {
 		utils.LogInfoUID(logger, "SudoRequest already processed", requestId, "state", sudoRequest.Status.State)
 		return ctrl.Result{}, nil
 	}
 
+	// End the request early when it was revoked
+	if sudoRequest.Spec.Revocation != nil {
+		return r.revokeRequest(ctx, &sudoRequest, sudoRequest.Spec.Revocation, requestId)
+	}
+
 	// Validate duration
 	duration, err := time.ParseDuration(sudoRequest.Spec.Duration)
 	if err != nil || duration <= 0 {
@@ -58,24 +78,62 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// Validate requester
-	requester := sudoRequest.Annotations["tarbac.io/requester"]
+	requester := sudoRequest.Spec.Requester.Username
 	if requester == "" {
 		return r.rejectRequest(ctx, &sudoRequest, "Requester information is missing", requestId)
 	}
 
-	// Validate referenced policy exists
-	var sudoPolicy v1.SudoPolicy
-	if err := r.Get(ctx, client.ObjectKey{Name: sudoRequest.Spec.Policy, Namespace: sudoRequest.Namespace}, &sudoPolicy); err != nil {
-		return r.rejectRequest(ctx, &sudoRequest, "Referenced policy not found", requestId)
+	// Select the policy of requests that ask for a role instead of naming a policy
+	policyNames := utils.RequestPolicies(&sudoRequest.Spec, &sudoRequest.Status)
+	if len(policyNames) == 0 {
+		if sudoRequest.Spec.RoleRef == nil {
+			return r.rejectRequest(ctx, &sudoRequest, "Either a policy, policies or a roleRef must be requested", requestId)
+		}
+		selected, err := r.selectPolicy(ctx, &sudoRequest, duration)
+		if err != nil {
+			return r.errorRequest(ctx, err, &sudoRequest, "Failed to list policies for the requested role", requestId)
+		}
+		if selected == "" {
+			return r.rejectRequest(ctx, &sudoRequest, fmt.Sprintf("No policy permits %s for this request", utils.DescribeRoleRef(*sudoRequest.Spec.RoleRef)), requestId)
+		}
+		policyNames = []string{selected}
+	}
+	if err := utils.ValidateBundle(policyNames); err != nil {
+		return r.rejectRequest(ctx, &sudoRequest, fmt.Sprintf("Invalid policy bundle: %v", err), requestId)
+	}
+	if len(policyNames) > 1 && (len(sudoRequest.Spec.Rules) > 0 || len(sudoRequest.Spec.ResourceNames) > 0) {
+		return r.rejectRequest(ctx, &sudoRequest, "Rules and resource names cannot be requested for a policy bundle", requestId)
+	}
+
+	// Validate referenced policies exist, a bundle is enforced under all of its policies
+	sudoPolicies := make([]v1.SudoPolicy, len(policyNames))
+	for i, policyName := range policyNames {
+		if err := r.Get(ctx, client.ObjectKey{Name: policyName, Namespace: sudoRequest.Namespace}, &sudoPolicies[i]); err != nil {
+			return r.rejectRequest(ctx, &sudoRequest, fmt.Sprintf("Referenced policy '%s' not found", policyName), requestId)
+		}
 	}
+	bundle := len(sudoPolicies) > 1
 
 	// Initial State
 	if sudoRequest.Status.State == "" {
 		// r.Recorder.Event(&sudoRequest, "Normal", "Submitted", fmt.Sprintf("User %s submitted a SudoRequest for policy %s for a duration of %s [UID: %s]", sudoRequest.Annotations["tarbac.io/requester"], sudoRequest.Spec.Policy, duration, string(sudoRequest.ObjectMeta.UID)))
-		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' submitted a SudoRequest for policy '%s' for a duration of %s", requester, sudoRequest.Spec.Policy, duration), requestId)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' submitted a SudoRequest for %s for a duration of %s", requester, utils.DescribePolicies(policyNames), duration), requestId)
 		r.Recorder.Event(&sudoRequest, "Normal", "Submitted", eventMessage)
+		if sudoRequest.Spec.RoleRef != nil {
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Policy '%s' was selected for %s", policyNames[0], utils.DescribeRoleRef(*sudoRequest.Spec.RoleRef)), requestId)
+			r.Recorder.Event(&sudoRequest, "Normal", "PolicySelected", eventMessage)
+		}
+		for _, sudoPolicy := range sudoPolicies {
+			if sudoPolicy.Spec.ApprovalMode == "Manual" {
+				eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' is waiting for approval by an approver of policy '%s'", requester, sudoPolicy.Name), requestId)
+				r.Recorder.Event(&sudoRequest, "Normal", "AwaitingApproval", eventMessage)
+			}
+		}
 		sudoRequest.Status.State = "Pending"
 		sudoRequest.Status.RequestID = requestId
+		if !bundle {
+			sudoRequest.Status.Policy = policyNames[0]
+		}
 
 		if err := r.Client.Status().Update(ctx, &sudoRequest); err != nil {
 			utils.LogErrorUID(logger, err, "Failed to set initial 'Pending' status", requestId, "SudoRequest", sudoRequest.Name)
@@ -93,28 +151,50 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	// If TemporaryRBAC is not yet created, create it
-	if sudoRequest.Status.State == "Pending" {
+	// If TemporaryRBAC is not yet created, create it. Queued requests are checked again until a grant of the policy ends
+	if sudoRequest.Status.State == "Pending" || sudoRequest.Status.State == "Queued" {
 
-		maxDuration, err := time.ParseDuration(sudoPolicy.Spec.MaxDuration)
-		if err != nil {
-			return r.errorRequest(ctx, err, &sudoRequest, "Invalid maxDuration in SudoPolicy spec", requestId)
+		if startTime := sudoRequest.Spec.StartTime; startTime != nil && !startTime.Add(duration).After(time.Now()) {
+			return r.rejectRequest(ctx, &sudoRequest, fmt.Sprintf("Requested access window starting at %s has already ended", startTime.Format(time.RFC3339)), requestId)
 		}
 
-		if duration > maxDuration {
-			return r.rejectRequest(ctx, &sudoRequest, fmt.Sprintf("Requested duration %s exceeds max allowed duration %s", duration, maxDuration), requestId)
+		namespaces := []string{sudoRequest.Namespace}
+
+		// The request is granted only when every policy of a bundle grants it
+		for i := range sudoPolicies {
+			if granted, result, err := r.checkPolicy(ctx, &sudoRequest, &sudoPolicies[i], bundle, duration, namespaces, requestId); !granted {
+				return result, err
+			}
 		}
 
-		if !r.validateRequester(sudoPolicy, requester) {
-			return r.rejectRequest(ctx, &sudoRequest, "User not allowed by policy", requestId)
+		if sudoRequest.Status.State == "Queued" {
+			sudoRequest.Status.QueuePosition = 0
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' left the queue of %s", requester, utils.DescribePolicies(policyNames)), requestId)
+			r.Recorder.Event(&sudoRequest, "Normal", "Dequeued", eventMessage)
 		}
 
-		namespaces := []string{sudoRequest.Namespace}
+		// Break-glass requests await review from the moment they are granted, the review state is persisted with the grant
+		if sudoRequest.Spec.BreakGlass {
+			sudoRequest.Status.ReviewState = "AwaitingReview"
+		}
 
 		// r.Recorder.Event(&sudoRequest, "Normal", "Approved", fmt.Sprintf("User '%s' was approved by '%s' SudoPolicy [UID: %s]", requester, sudoPolicy.Name, requestId))
-		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' was approved by '%s' SudoPolicy", requester, sudoPolicy.Name), requestId)
+		approvedBy := fmt.Sprintf("'%s' SudoPolicy", policyNames[0])
+		if bundle {
+			approvedBy = fmt.Sprintf("all of SudoPolicies '%s'", strings.Join(policyNames, "', '"))
+		}
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("User '%s' was approved by %s%s", requester, approvedBy, utils.DescribeReferences(sudoRequest.Spec.Justification, sudoRequest.Spec.Ticket)), requestId)
 		r.Recorder.Event(&sudoRequest, "Normal", "Approved", eventMessage)
-		return r.createTemporaryRBACsForNamespaces(ctx, &sudoRequest, namespaces, &sudoPolicy, requester, logger, requestId)
+		result, err := r.createTemporaryRBACsForNamespaces(ctx, &sudoRequest, namespaces, sudoPolicies, requester, logger, requestId)
+		if err != nil || sudoRequest.Status.State != "Approved" || !sudoRequest.Spec.BreakGlass {
+			return result, err
+		}
+		// Only label and announce the request once its grant is persisted, so that the label update cannot drop it
+		if err := r.markBreakGlass(ctx, &sudoRequest, policyNames, requester, requestId); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to mark break-glass SudoRequest for review", requestId)
+			return ctrl.Result{}, err
+		}
+		return result, nil
 	}
 
 	// If the TemporaryRBAC is already created, fetch and update SudoRequest status
@@ -122,6 +202,26 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 		utils.LogInfoUID(logger, "SudoRequest is already approved, validating child resource", requestId)
 
+		// Mark a break-glass request whose grant was persisted before it could be labelled for review
+		if sudoRequest.Spec.BreakGlass && sudoRequest.Status.ReviewState == "AwaitingReview" {
+			if err := r.markBreakGlass(ctx, &sudoRequest, policyNames, requester, requestId); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to mark break-glass SudoRequest for review", requestId)
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Revoke the request when an emergency lockdown covers any of its policies
+		for _, policyName := range policyNames {
+			lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{Namespaces: []string{sudoRequest.Namespace}, Policy: policyName, PolicyKind: "SudoPolicy", Users: []string{requester}})
+			if err != nil {
+				utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+				return ctrl.Result{}, err
+			}
+			if lockdown != nil {
+				return r.revokeRequest(ctx, &sudoRequest, utils.LockdownRevocation(lockdown), requestId)
+			}
+		}
+
 		for _, childResource := range sudoRequest.Status.ChildResource {
 
 			if childResource.Name == "" {
@@ -138,7 +238,7 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					if apierrors.IsNotFound(err) {
 						utils.LogErrorUID(logger, err, "Child TemporaryRBAC resource not found", requestId, "child", childResource)
 						// r.Recorder.Event(&sudoRequest, "Warning", "MissingChildResource", fmt.Sprintf("Child resource %s/%s not found", childResource.Namespace, childResource.Name))
-						eventMessage := utils.FormatEventMessage(fmt.Sprintf("Child resource %s/%s not found in namespace", childResource.Kind, childResource.Name, childResource.Namespace), requestId)
+						eventMessage := utils.FormatEventMessage(fmt.Sprintf("Child resource %s/%s not found in namespace %s", childResource.Kind, childResource.Name, childResource.Namespace), requestId)
 						r.Recorder.Event(&sudoRequest, "Warning", "MissingChildResource", eventMessage)
 						continue
 					}
@@ -161,7 +261,7 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 						return r.errorRequest(ctx, err, &sudoRequest, "Failed to update expired SudoRequest status", requestId)
 					}
 					// r.Recorder.Event(&sudoRequest, "Warning", "Expired", fmt.Sprintf("SudoRequest Expired for User %s, revoked permissions for policy %s [UID: %s]", requester, sudoRequest.Spec.Policy, requestId))
-					eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest Expired for User '%s', revoked permissions for policy '%s'", requester, sudoRequest.Spec.Policy), requestId)
+					eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest Expired for User '%s', revoked permissions for %s", requester, utils.DescribePolicies(policyNames)), requestId)
 					r.Recorder.Event(&sudoRequest, "Warning", "Expired", eventMessage)
 					utils.LogInfoUID(logger, "SudoRequest has expired", requestId, "name", sudoRequest.Name)
 					return ctrl.Result{}, nil
@@ -171,7 +271,7 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 						return r.errorRequest(ctx, err, &sudoRequest, "Failed to update expired SudoRequest status", requestId)
 					}
 					// r.Recorder.Event(&sudoRequest, "Error", "Error", fmt.Sprintf("Error detected while processing SudoRequest for User '%s' and policy '%s' [UID: %s]", requester, sudoRequest.Spec.Policy, requestId))
-					eventMessage := utils.FormatEventMessage(fmt.Sprintf("Error detected while processing SudoRequest for User '%s' and policy '%s'", requester, sudoRequest.Spec.Policy), requestId)
+					eventMessage := utils.FormatEventMessage(fmt.Sprintf("Error detected while processing SudoRequest for User '%s' and %s", requester, utils.DescribePolicies(policyNames)), requestId)
 					r.Recorder.Event(&sudoRequest, "Error", "Error", eventMessage)
 					utils.LogInfoUID(logger, "SudoRequest has errors", requestId, "name", sudoRequest.Name)
 					return ctrl.Result{}, nil
@@ -179,6 +279,12 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			}
 		}
 
+		// Extend the grant when requested
+		if err := r.processExtensions(ctx, &sudoRequest, sudoPolicies, requester, requestId); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to extend SudoRequest", requestId)
+			return ctrl.Result{}, err
+		}
+
 		// Update the SudoRequest status
 		if err := r.Client.Status().Update(ctx, &sudoRequest); err != nil {
 			return r.errorRequest(ctx, err, &sudoRequest, "Failed to update SudoRequest status after child resource validation", requestId)
@@ -189,13 +295,321 @@ func (r *SudoRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	return ctrl.Result{}, nil
 }
 
-func (r *SudoRequestReconciler) validateRequester(policy v1.SudoPolicy, requester string) bool {
-	for _, user := range policy.Spec.AllowedUsers {
-		if user.Name == requester {
-			return true
+// checkPolicy checks a pending or queued SudoRequest against one of its policies and reports whether the
+// policy grants it. Otherwise the request has been rejected, queued or put on hold, and the returned result
+// ends the reconciliation. Each policy of a bundle is checked on its own and its rejections name it.
+func (r *SudoRequestReconciler) checkPolicy(ctx context.Context, sudoRequest *v1.SudoRequest, sudoPolicy *v1.SudoPolicy, bundle bool, duration time.Duration, namespaces []string, requestId string) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	requester := sudoRequest.Spec.Requester.Username
+
+	reject := func(message string) (bool, ctrl.Result, error) {
+		if bundle {
+			message = fmt.Sprintf("Policy '%s': %s", sudoPolicy.Name, message)
+		}
+		result, err := r.rejectRequest(ctx, sudoRequest, message, requestId)
+		return false, result, err
+	}
+	fail := func(err error, message string) (bool, ctrl.Result, error) {
+		if bundle {
+			message = fmt.Sprintf("Policy '%s': %s", sudoPolicy.Name, message)
+		}
+		result, err := r.errorRequest(ctx, err, sudoRequest, message, requestId)
+		return false, result, err
+	}
+
+	maxDuration, err := time.ParseDuration(sudoPolicy.Spec.MaxDuration)
+	if err != nil {
+		return fail(err, "Invalid maxDuration in SudoPolicy spec")
+	}
+
+	if duration > maxDuration {
+		return reject(fmt.Sprintf("Requested duration %s exceeds max allowed duration %s", duration, maxDuration))
+	}
+
+	if err := utils.CheckReferences(sudoPolicy.Spec, sudoRequest.Spec.Justification, sudoRequest.Spec.Ticket); err != nil {
+		return reject(fmt.Sprintf("Request does not satisfy the requirements of the policy: %v", err))
+	}
+
+	// Limit how often and for how long the requester is granted access, break-glass requests are exempt
+	if utils.HasQuotas(sudoPolicy.Spec) && !sudoRequest.Spec.BreakGlass {
+		grants, err := utils.SudoRequestGrants(ctx, r.Client, sudoRequest.Namespace, sudoPolicy.Name, requester, sudoRequest.Name)
+		if err != nil {
+			return fail(err, "Failed to list previous requests for the quota check")
+		}
+		reason, err := utils.CheckQuotas(sudoPolicy.Spec, grants, duration, utils.ActivationTime(sudoRequest.Spec.StartTime, time.Now()))
+		if err != nil {
+			return fail(err, "Invalid quotas in SudoPolicy spec")
+		}
+		if reason != "" {
+			return reject(fmt.Sprintf("Request exceeds the quotas of the policy: %s", reason))
+		}
+	}
+
+	// Break-glass requests must be allowed and justified, and are limited by the requests awaiting review
+	if sudoRequest.Spec.BreakGlass {
+		reason, err := utils.CheckBreakGlass(ctx, r.Client, sudoPolicy.Spec, sudoRequest.Spec.Justification, requester)
+		if err != nil {
+			return fail(err, "Failed to check break-glass requests awaiting review")
+		}
+		if reason != "" {
+			return reject(reason)
+		}
+	}
+
+	// The schedule of the policy applies to when the access starts, not when it is requested
+	activatesAt := utils.ActivationTime(sudoRequest.Spec.StartTime, time.Now())
+	schedule, err := utils.EvaluateSchedule(sudoPolicy.Spec.Schedule, activatesAt)
+	if err != nil {
+		return fail(err, "Invalid schedule in policy spec")
+	}
+	if !schedule.Allowed && !sudoRequest.Spec.BreakGlass {
+		return reject(fmt.Sprintf("Request is outside the schedule of the policy: %s", schedule.Reason))
+	}
+
+	if !r.validateRequester(*sudoPolicy, sudoRequest.Spec.Requester) {
+		return reject("User not allowed by policy")
+	}
+
+	if err := utils.CheckRequiredAttributes(sudoPolicy.Spec, sudoRequest.Spec.Requester); err != nil {
+		return reject(fmt.Sprintf("User does not satisfy the attribute requirements of the policy: %v", err))
+	}
+
+	// Requests for a subset of the permissions of the policy are only granted what the policy grants
+	if len(sudoRequest.Spec.Rules) > 0 {
+		reason, err := utils.CheckRequestedRules(ctx, r.Client, sudoPolicy.Spec, sudoRequest.Spec.Rules, namespaces)
+		if err != nil {
+			return fail(err, "Failed to resolve the permissions of the policy")
+		}
+		if reason != "" {
+			return reject(fmt.Sprintf("Requested rules are not covered by the policy: %s", reason))
+		}
+	}
+
+	// Requests restricted to some objects may only name objects the policy allows and grants access to
+	if len(sudoRequest.Spec.ResourceNames) > 0 {
+		reason, err := utils.CheckResourceNames(ctx, r.Client, sudoPolicy.Spec, sudoRequest.Spec.Rules, sudoRequest.Spec.ResourceNames, namespaces)
+		if err != nil {
+			return fail(err, "Failed to resolve the permissions of the policy")
+		}
+		if reason != "" {
+			return reject(fmt.Sprintf("Requested resource names are not granted by the policy: %s", reason))
+		}
+	}
+
+	if err := utils.EvaluateConditions(sudoPolicy.Spec, utils.ConditionInput{
+		Name:       sudoRequest.Name,
+		Namespace:  sudoRequest.Namespace,
+		Policy:     sudoPolicy.Name,
+		Duration:   duration,
+		Requester:  sudoRequest.Spec.Requester,
+		Namespaces: namespaces,
+		Now:        time.Now(),
+	}); err != nil {
+		return reject(fmt.Sprintf("Request does not satisfy the conditions of the policy: %v", err))
+	}
+
+	if r.DecisionClient != nil {
+		decision, err := r.DecisionClient.Decide(ctx, utils.DecisionInput{
+			Kind:           "SudoRequest",
+			Name:           sudoRequest.Name,
+			Namespace:      sudoRequest.Namespace,
+			Policy:         sudoPolicy.Name,
+			Duration:       sudoRequest.Spec.Duration,
+			Requester:      sudoRequest.Spec.Requester,
+			RoleRef:        sudoPolicy.Spec.RoleRef,
+			AccessProfile:  sudoPolicy.Spec.AccessProfile,
+			Rules:          sudoPolicy.Spec.Rules,
+			RequestedRules: sudoRequest.Spec.Rules,
+			ResourceNames:  sudoRequest.Spec.ResourceNames,
+			Namespaces:     namespaces,
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to query the external decision point", requestId, "allow", decision.Allow)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("External decision point could not be queried: %v", err), requestId)
+			r.Recorder.Event(sudoRequest, "Warning", "DecisionPointUnavailable", eventMessage)
+			// Failing closed holds the request until the decision point answers, only an explicit deny rejects it
+			if !decision.Allow {
+				return false, ctrl.Result{}, err
+			}
+		}
+		if !decision.Allow {
+			return reject(fmt.Sprintf("Request denied by the external decision point: %s", decision.Reason))
+		}
+	}
+
+	// Confirm that the ticket exists and is open, the request is retried while the validator is unavailable
+	if r.TicketValidator != nil && sudoRequest.Spec.Ticket != "" {
+		result, err := r.TicketValidator.Validate(ctx, utils.TicketInput{
+			Ticket:    sudoRequest.Spec.Ticket,
+			Kind:      "SudoRequest",
+			Name:      sudoRequest.Name,
+			Namespace: sudoRequest.Namespace,
+			Policy:    sudoPolicy.Name,
+			Requester: requester,
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to validate ticket", requestId, "ticket", sudoRequest.Spec.Ticket)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Ticket '%s' could not be validated: %v", sudoRequest.Spec.Ticket, err), requestId)
+			r.Recorder.Event(sudoRequest, "Warning", "TicketValidatorUnavailable", eventMessage)
+			return false, ctrl.Result{}, err
+		}
+		if !result.Valid {
+			return reject(fmt.Sprintf("Ticket '%s' was rejected by the ticket validator: %s", sudoRequest.Spec.Ticket, result.Reason))
+		}
+	}
+
+	// Hold the request while an emergency lockdown covers it, it is reconciled again once the lockdown is lifted
+	lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{Namespaces: namespaces, Policy: sudoPolicy.Name, PolicyKind: "SudoPolicy", Users: []string{requester}})
+	if err != nil {
+		utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+		return false, ctrl.Result{}, err
+	}
+	if lockdown != nil {
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' is on hold while lockdown '%s' is in place: %s", requester, lockdown.Name, lockdown.Spec.Reason), requestId)
+		r.Recorder.Event(sudoRequest, "Warning", "LockedDown", eventMessage)
+		return false, ctrl.Result{}, nil
+	}
+
+	if sudoPolicy.Spec.ApprovalMode == "Manual" && !sudoRequest.Spec.BreakGlass {
+		approved, result, err := r.waitForApprovals(ctx, sudoRequest, sudoPolicy, requester, requestId)
+		if !approved {
+			return false, result, err
+		}
+	}
+
+	// Enforce the concurrency limits of the policy and handle duplicates of active grants, break-glass requests are exempt
+	if utils.HasConcurrencyLimits(sudoPolicy.Spec) && !sudoRequest.Spec.BreakGlass {
+		active, err := utils.ActiveSudoRequests(ctx, r.Client, sudoRequest.Namespace, sudoPolicy.Name, sudoRequest.Name)
+		if err != nil {
+			return fail(err, "Failed to list active requests for the concurrency check")
+		}
+		if duplicate := utils.FindDuplicate(active, requester, utils.ActivationTime(sudoRequest.Spec.StartTime, time.Now())); duplicate != nil {
+			switch sudoPolicy.Spec.OnDuplicate {
+			case "Reject":
+				return reject(fmt.Sprintf("Duplicate of active SudoRequest '%s'", duplicate.Name))
+			case "Merge":
+				// The policies of a bundle are granted together, so a bundle is never merged into another grant
+				if !bundle {
+					result, err := r.mergeRequest(ctx, sudoRequest, duplicate.Name, sudoPolicy, duration, requestId)
+					return false, result, err
+				}
+			}
+		}
+		// Requests waiting in the queue of the policy are granted first
+		queued, err := utils.QueuedSudoRequests(ctx, r.Client, sudoRequest.Namespace, sudoPolicy.Name)
+		if err != nil {
+			return fail(err, "Failed to list queued requests for the concurrency check")
+		}
+		ahead := utils.RequestsAhead(queued, sudoRequest.Name)
+		if reason := utils.CheckConcurrency(sudoPolicy.Spec, append(active, ahead...), requester); reason != "" {
+			if sudoPolicy.Spec.OnLimitReached == "Queue" {
+				result, err := r.queueRequest(ctx, sudoRequest, len(ahead)+1, reason, requestId)
+				return false, result, err
+			}
+			return reject(fmt.Sprintf("Request exceeds the concurrency limits of the policy: %s", reason))
+		}
+	}
+
+	return true, ctrl.Result{}, nil
+}
+
+// selectPolicy returns the name of the SudoPolicy of the namespace that best permits the role requested by a
+// SudoRequest, or an empty string when none does.
+func (r *SudoRequestReconciler) selectPolicy(ctx context.Context, sudoRequest *v1.SudoRequest, duration time.Duration) (string, error) {
+	var sudoPolicies v1.SudoPolicyList
+	if err := r.List(ctx, &sudoPolicies, client.InNamespace(sudoRequest.Namespace)); err != nil {
+		return "", err
+	}
+	var candidates []utils.PolicyCandidate
+	for _, sudoPolicy := range sudoPolicies.Items {
+		if sudoPolicy.Status.State == "Error" {
+			continue
+		}
+		candidates = append(candidates, utils.PolicyCandidate{Name: sudoPolicy.Name, Spec: sudoPolicy.Spec})
+	}
+	selected := utils.SelectPolicy(candidates, utils.PolicyRequest{
+		RoleRef:    *sudoRequest.Spec.RoleRef,
+		Duration:   duration,
+		Requester:  sudoRequest.Spec.Requester,
+		BreakGlass: sudoRequest.Spec.BreakGlass,
+	})
+	if selected == nil {
+		return "", nil
+	}
+	return selected.Name, nil
+}
+
+func (r *SudoRequestReconciler) validateRequester(policy v1.SudoPolicy, requester v1.UserIdentity) bool {
+	return utils.IsAllowedRequester(policy.Spec, requester)
+}
+
+// waitForApprovals records the approvals of a manually approved request and reports whether its quorum is met.
+// Until then, further escalation tiers are announced as they become eligible and the request is rejected
+// once it has been pending for longer than the policy's pendingTTL.
+func (r *SudoRequestReconciler) waitForApprovals(ctx context.Context, sudoRequest *v1.SudoRequest, sudoPolicy *v1.SudoPolicy, requester string, requestId string) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	now := time.Now()
+	submittedAt := sudoRequest.CreationTimestamp.Time
+
+	requiredApprovals := utils.RequiredApprovals(sudoPolicy.Spec)
+	approvals := utils.EligibleApprovals(sudoPolicy.Spec, sudoRequest.Spec.Approvals, requester, submittedAt)
+	statusChanged := false
+	// Approvals already recorded for the other policies of a bundle are kept
+	for i, approval := range approvals {
+		if utils.HasApproval(sudoRequest.Status.Approvals, approval) {
+			continue
+		}
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' was signed off by approver '%s' (%d/%d)", requester, approval.Approver.Username, i+1, requiredApprovals), requestId)
+		r.Recorder.Event(sudoRequest, "Normal", "ApprovalGranted", eventMessage)
+		sudoRequest.Status.Approvals = append(sudoRequest.Status.Approvals, approval)
+		statusChanged = true
+	}
+	if len(approvals) >= requiredApprovals {
+		return true, ctrl.Result{}, nil
+	}
+
+	// Reject the request if it was not approved in time
+	var deadline time.Time
+	if sudoPolicy.Spec.PendingTTL != "" {
+		pendingTTL, err := time.ParseDuration(sudoPolicy.Spec.PendingTTL)
+		if err != nil {
+			result, err := r.errorRequest(ctx, err, sudoRequest, "Invalid pendingTTL in SudoPolicy spec", requestId)
+			return false, result, err
+		}
+		deadline = submittedAt.Add(pendingTTL)
+		if !now.Before(deadline) {
+			result, err := r.rejectRequest(ctx, sudoRequest, fmt.Sprintf("Request was not approved within %s (%d/%d approvals)", pendingTTL, len(approvals), requiredApprovals), requestId)
+			return false, result, err
+		}
+	}
+
+	// Announce escalation tiers that became eligible since the last reconciliation
+	level, nextEscalation := utils.EscalationLevel(sudoPolicy.Spec, submittedAt, now)
+	for i := sudoRequest.Status.EscalationLevel; i < level && i < len(sudoPolicy.Spec.Escalations); i++ {
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' was escalated to approvers %v after %s", requester, sudoPolicy.Spec.Escalations[i].Approvers, sudoPolicy.Spec.Escalations[i].After), requestId)
+		r.Recorder.Event(sudoRequest, "Normal", "Escalated", eventMessage)
+	}
+	if level > sudoRequest.Status.EscalationLevel {
+		sudoRequest.Status.EscalationLevel = level
+		statusChanged = true
+	}
+
+	if statusChanged {
+		if err := r.Status().Update(ctx, sudoRequest); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update SudoRequest status with approvals", requestId)
+			return false, ctrl.Result{}, err
+		}
+	}
+
+	// Requeue for the next escalation or the pending deadline, whichever comes first
+	var requeueAfter time.Duration
+	for _, next := range []time.Time{deadline, nextEscalation} {
+		if !next.IsZero() && (requeueAfter == 0 || time.Until(next) < requeueAfter) {
+			requeueAfter = time.Until(next)
 		}
 	}
-	return false
+	utils.LogInfoUID(logger, "SudoRequest is waiting for approval", requestId, "policy", sudoPolicy.Name, "approvals", len(approvals), "requiredApprovals", requiredApprovals, "requeueAfter", requeueAfter)
+	return false, ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 func (r *SudoRequestReconciler) rejectRequest(ctx context.Context, sudoRequest *v1.SudoRequest, message string, requestID string) (ctrl.Result, error) {
@@ -240,45 +654,379 @@ func (r *SudoRequestReconciler) getRequestID(sudoRequest *v1.SudoRequest) string
 	return requestId
 }
 
-func (r *SudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx context.Context, sudoRequest *v1.SudoRequest, namespaces []string, sudoPolicy *v1.SudoPolicy, requester string, logger logr.Logger, requestId string) (ctrl.Result, error) {
-	var childResources []v1.ChildResource
+// grantDuration returns the duration granted to a request from its activation, which may be cut short by the schedule
+// of its policies. All the policies of a bundle share the shortest duration, so that they expire together.
+func (r *SudoRequestReconciler) grantDuration(sudoRequest *v1.SudoRequest, sudoPolicies []v1.SudoPolicy, requestId string) string {
+	if sudoRequest.Spec.BreakGlass {
+		// Break-glass access is not bound to the schedule of the policy
+		return sudoRequest.Spec.Duration
+	}
+	durations := make([]string, 0, len(sudoPolicies))
+	for _, sudoPolicy := range sudoPolicies {
+		duration := utils.GrantDuration(sudoPolicy.Spec, sudoRequest.Spec.Duration, utils.ActivationTime(sudoRequest.Spec.StartTime, time.Now()))
+		if duration != sudoRequest.Spec.Duration {
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Requested duration %s was cut short to %s by the schedule of policy '%s'", sudoRequest.Spec.Duration, duration, sudoPolicy.Name), requestId)
+			r.Recorder.Event(sudoRequest, "Normal", "GrantShortened", eventMessage)
+		}
+		durations = append(durations, duration)
+	}
+	return utils.ShortestDuration(durations)
+}
 
-	for _, namespace := range namespaces {
-		temporaryRBAC := &v1.TemporaryRBAC{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      utils.GenerateTempRBACName(rbacv1.Subject{Kind: "User", Name: requester}, sudoRequest.Spec.Policy, sudoRequest.Status.RequestID),
-				Namespace: namespace,
-			},
-			Spec: v1.TemporaryRBACSpec{
-				Subjects: []rbacv1.Subject{
-					{
-						Kind: "User",
-						Name: requester,
-					},
-				},
-				RoleRef:  sudoPolicy.Spec.RoleRef,
-				Duration: sudoRequest.Spec.Duration,
-			},
+// processExtensions handles the extension requests of an approved SudoRequest in order. Each extension is validated
+// against the maxDuration and maxTotalDuration of every policy of the request and, for each policy requiring manual
+// approval, waits for a new quorum of approvals given after it was requested. Granted extensions push out the expiration
+// of the request and of all of its child resources, and every outcome is recorded in the status.
+func (r *SudoRequestReconciler) processExtensions(ctx context.Context, sudoRequest *v1.SudoRequest, sudoPolicies []v1.SudoPolicy, requester string, requestId string) error {
+	logger := log.FromContext(ctx)
+
+	for i := utils.ProcessedExtensions(sudoRequest.Status.Extensions); i < len(sudoRequest.Spec.Extensions); i++ {
+		extension := sudoRequest.Spec.Extensions[i]
+		if sudoRequest.Status.CreatedAt == nil || sudoRequest.Status.ExpiresAt == nil {
+			utils.LogInfoUID(logger, "Extension requested before the grant was created, waiting", requestId)
+			return nil
 		}
+		now := time.Now()
+		record := v1.ExtensionRecord{Duration: extension.Duration, Timestamp: metav1.Time{Time: now}}
 
-		if err := controllerutil.SetControllerReference(sudoRequest, temporaryRBAC, r.Scheme); err != nil {
-			utils.LogErrorUID(logger, err, "Failed to set OwnerReference on TemporaryRBAC", requestId)
+		var duration time.Duration
+		var err error
+		for _, sudoPolicy := range sudoPolicies {
+			if duration, err = utils.CheckExtension(sudoPolicy.Spec, extension.Duration, sudoRequest.Status.CreatedAt.Time, sudoRequest.Status.ExpiresAt.Time); err != nil {
+				if len(sudoPolicies) > 1 {
+					err = fmt.Errorf("policy '%s': %v", sudoPolicy.Name, err)
+				}
+				break
+			}
+		}
+		if err == nil && !now.Before(sudoRequest.Status.ExpiresAt.Time) {
+			err = fmt.Errorf("the grant has already expired")
+		}
+		if err != nil {
+			record.State = "Rejected"
+			record.Message = err.Error()
+			sudoRequest.Status.Extensions = append(sudoRequest.Status.Extensions, record)
+			eventMessage := utils.FormatEventMessage(fmt.Sprintf("Extension of %s requested by user '%s' was rejected: %v", extension.Duration, requester, err), requestId)
+			r.Recorder.Event(sudoRequest, "Warning", "ExtensionRejected", eventMessage)
 			continue
 		}
 
-		if err := r.Client.Create(ctx, temporaryRBAC); err != nil {
-			utils.LogErrorUID(logger, err, "Failed to create TemporaryRBAC", requestId)
-			continue
+		for _, sudoPolicy := range sudoPolicies {
+			if sudoPolicy.Spec.ApprovalMode != "Manual" {
+				continue
+			}
+			approvals := utils.EligibleApprovals(sudoPolicy.Spec, utils.ApprovalsSince(sudoRequest.Spec.Approvals, extension.Timestamp.Time), requester, extension.Timestamp.Time)
+			if required := utils.RequiredApprovals(sudoPolicy.Spec); len(approvals) < required {
+				utils.LogInfoUID(logger, "Extension is waiting for approval", requestId, "extension", extension.Duration, "policy", sudoPolicy.Name, "approvals", len(approvals), "requiredApprovals", required)
+				return nil
+			}
+		}
+
+		expiresAt := metav1.Time{Time: sudoRequest.Status.ExpiresAt.Add(duration)}
+		if err := r.extendGrant(ctx, sudoRequest, expiresAt); err != nil {
+			return err
+		}
+
+		record.State = "Granted"
+		record.ExpiresAt = expiresAt.DeepCopy()
+		sudoRequest.Status.Extensions = append(sudoRequest.Status.Extensions, record)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' was extended by %s until %s", requester, duration, expiresAt.Format(time.RFC3339)), requestId)
+		r.Recorder.Event(sudoRequest, "Normal", "Extended", eventMessage)
+	}
+	return nil
+}
+
+// extendGrant moves the expiration of the grant of a SudoRequest and of all of its children to expiresAt.
+func (r *SudoRequestReconciler) extendGrant(ctx context.Context, sudoRequest *v1.SudoRequest, expiresAt metav1.Time) error {
+	for _, child := range sudoRequest.Status.ChildResource {
+		var temporaryRBAC v1.TemporaryRBAC
+		if err := r.Get(ctx, client.ObjectKey{Name: child.Name, Namespace: child.Namespace}, &temporaryRBAC); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		temporaryRBAC.Status.ExpiresAt = expiresAt.DeepCopy()
+		if err := r.Status().Update(ctx, &temporaryRBAC); err != nil {
+			return err
 		}
+	}
+	sudoRequest.Status.ExpiresAt = expiresAt.DeepCopy()
+	return nil
+}
 
-		utils.LogInfoUID(logger, "TemporaryRBAC created successfully", requestId, "temporaryRBAC", temporaryRBAC.Name, "namespace", temporaryRBAC.Namespace)
+// mergeRequest merges a duplicate SudoRequest into the active request of the same user, extending the active grant
+// when the duplicate would have lasted longer. The duplicate moves to the terminal Merged state.
+func (r *SudoRequestReconciler) mergeRequest(ctx context.Context, sudoRequest *v1.SudoRequest, target string, sudoPolicy *v1.SudoPolicy, duration time.Duration, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
 
-		childResources = append(childResources, v1.ChildResource{
-			APIVersion: "tarbac.io/v1",
-			Kind:       "TemporaryRBAC",
-			Name:       temporaryRBAC.Name,
-			Namespace:  namespace,
+	var existing v1.SudoRequest
+	if err := r.Get(ctx, client.ObjectKey{Name: target, Namespace: sudoRequest.Namespace}, &existing); err != nil {
+		return r.errorRequest(ctx, err, sudoRequest, "Failed to fetch the SudoRequest to merge into", requestId)
+	}
+	if existing.Status.CreatedAt == nil || existing.Status.ExpiresAt == nil {
+		utils.LogInfoUID(logger, "Waiting for the grant of the SudoRequest to merge into", requestId, "target", target)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	expiresAt := utils.ActivationTime(sudoRequest.Spec.StartTime, time.Now()).Add(duration)
+	if expiresAt.After(existing.Status.ExpiresAt.Time) {
+		extension := expiresAt.Sub(existing.Status.ExpiresAt.Time).Round(time.Second)
+		if _, err := utils.CheckExtension(sudoPolicy.Spec, extension.String(), existing.Status.CreatedAt.Time, existing.Status.ExpiresAt.Time); err != nil {
+			return r.rejectRequest(ctx, sudoRequest, fmt.Sprintf("Duplicate of active SudoRequest '%s', which cannot be extended: %v", target, err), requestId)
+		}
+		extendedAt := metav1.Time{Time: existing.Status.ExpiresAt.Add(extension)}
+		if err := r.extendGrant(ctx, &existing, extendedAt); err != nil {
+			return r.errorRequest(ctx, err, sudoRequest, "Failed to extend the SudoRequest to merge into", requestId)
+		}
+		existing.Status.Extensions = append(existing.Status.Extensions, v1.ExtensionRecord{
+			Duration:   extension.String(),
+			State:      "Granted",
+			Timestamp:  metav1.Now(),
+			ExpiresAt:  extendedAt.DeepCopy(),
+			MergedFrom: sudoRequest.Name,
 		})
+		if err := r.Status().Update(ctx, &existing); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update the status of the SudoRequest merged into", requestId, "target", target)
+			return ctrl.Result{}, err
+		}
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("Duplicate SudoRequest '%s' was merged, extending the grant by %s until %s", sudoRequest.Name, extension, existing.Status.ExpiresAt.Format(time.RFC3339)), existing.Status.RequestID)
+		r.Recorder.Event(&existing, "Normal", "Merged", eventMessage)
+	}
+
+	sudoRequest.Status.State = "Merged"
+	sudoRequest.Status.MergedInto = target
+	sudoRequest.Status.ExpiresAt = existing.Status.ExpiresAt.DeepCopy()
+	if err := r.Status().Update(ctx, sudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update SudoRequest status to Merged", requestId)
+		return ctrl.Result{}, err
+	}
+	eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' was merged into active SudoRequest '%s', which expires at %s", sudoRequest.Spec.Requester.Username, target, existing.Status.ExpiresAt.Format(time.RFC3339)), requestId)
+	r.Recorder.Event(sudoRequest, "Normal", "Merged", eventMessage)
+	return ctrl.Result{}, nil
+}
+
+// queueRequest places a SudoRequest over the concurrency limits of its policy in the queue of the policy, or
+// records its new position. Queued requests are reconciled again whenever a grant of the policy changes.
+func (r *SudoRequestReconciler) queueRequest(ctx context.Context, sudoRequest *v1.SudoRequest, position int, reason string, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if sudoRequest.Status.State == "Queued" && sudoRequest.Status.QueuePosition == position {
+		utils.LogInfoUID(logger, "SudoRequest is still queued", requestId, "position", position, "reason", reason)
+		return ctrl.Result{}, nil
+	}
+	queued := sudoRequest.Status.State != "Queued"
+	sudoRequest.Status.State = "Queued"
+	sudoRequest.Status.QueuePosition = position
+	if sudoRequest.Status.QueuedAt == nil {
+		sudoRequest.Status.QueuedAt = &metav1.Time{Time: time.Now()}
+	}
+	if err := r.Status().Update(ctx, sudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update SudoRequest status to Queued", requestId)
+		return ctrl.Result{}, err
+	}
+	if queued {
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("SudoRequest of user '%s' was queued at position %d: %s", sudoRequest.Spec.Requester.Username, position, reason), requestId)
+		r.Recorder.Event(sudoRequest, "Normal", "Queued", eventMessage)
+	}
+	utils.LogInfoUID(logger, "SudoRequest is queued", requestId, "position", position, "reason", reason)
+	return ctrl.Result{}, nil
+}
+
+// markBreakGlass labels a granted break-glass SudoRequest as awaiting review and raises a warning event and a
+// notification, so that the use of break-glass access never goes unnoticed. Requests are only labelled once, so
+// the notification is not sent again when a later reconcile marks the request.
+func (r *SudoRequestReconciler) markBreakGlass(ctx context.Context, sudoRequest *v1.SudoRequest, policyNames []string, requester string, requestId string) error {
+	logger := log.FromContext(ctx)
+
+	if sudoRequest.Labels[utils.PendingReviewLabel] == "true" {
+		return nil
+	}
+	if sudoRequest.Labels == nil {
+		sudoRequest.Labels = make(map[string]string)
+	}
+	sudoRequest.Labels[utils.PendingReviewLabel] = "true"
+	if err := r.Update(ctx, sudoRequest); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("User '%s' broke the glass of %s for %s: %s", requester, utils.DescribePolicies(policyNames), sudoRequest.Spec.Duration, sudoRequest.Spec.Justification)
+	r.Recorder.Event(sudoRequest, "Warning", "BreakGlass", utils.FormatEventMessage(message, requestId))
+	utils.LogInfoUID(logger, "Break-glass SudoRequest granted, awaiting review", requestId, "justification", sudoRequest.Spec.Justification)
+
+	if err := r.Notifier.Notify(ctx, utils.Notification{
+		Severity:      "critical",
+		Reason:        "BreakGlass",
+		Kind:          "SudoRequest",
+		Name:          sudoRequest.Name,
+		Namespace:     sudoRequest.Namespace,
+		RequestID:     requestId,
+		Requester:     requester,
+		Policy:        strings.Join(policyNames, ","),
+		Justification: sudoRequest.Spec.Justification,
+		Message:       message,
+	}); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to send break-glass notification", requestId)
+		eventMessage := utils.FormatEventMessage(fmt.Sprintf("Break-glass notification could not be sent: %v", err), requestId)
+		r.Recorder.Event(sudoRequest, "Warning", "NotificationFailed", eventMessage)
+	}
+	return nil
+}
+
+// processReview accepts the first review of a break-glass SudoRequest given by a reviewer of the policy, or of every
+// policy of a bundle, which ends its awaiting review condition. Reviews by anyone else are ignored.
+func (r *SudoRequestReconciler) processReview(ctx context.Context, sudoRequest *v1.SudoRequest, requestId string) error {
+	logger := log.FromContext(ctx)
+
+	var policySpecs []v1.SudoPolicySpec
+	for _, policyName := range utils.RequestPolicies(&sudoRequest.Spec, &sudoRequest.Status) {
+		var sudoPolicy v1.SudoPolicy
+		if err := r.Get(ctx, client.ObjectKey{Name: policyName, Namespace: sudoRequest.Namespace}, &sudoPolicy); err != nil {
+			return err
+		}
+		policySpecs = append(policySpecs, sudoPolicy.Spec)
+	}
+	review := utils.EligibleBundleReview(policySpecs, sudoRequest.Spec.Reviews, sudoRequest.Spec.Requester.Username)
+	if review == nil {
+		utils.LogInfoUID(logger, "No review by a reviewer of the policy yet", requestId)
+		return nil
+	}
+
+	sudoRequest.Status.ReviewState = "Reviewed"
+	sudoRequest.Status.Review = &v1.Review{}
+	review.DeepCopyInto(sudoRequest.Status.Review)
+	if err := r.Status().Update(ctx, sudoRequest); err != nil {
+		return err
+	}
+	delete(sudoRequest.Labels, utils.PendingReviewLabel)
+	if err := r.Update(ctx, sudoRequest); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Break-glass SudoRequest of user '%s' was reviewed by '%s'", sudoRequest.Spec.Requester.Username, sudoRequest.Status.Review.Reviewer.Username)
+	if comment := sudoRequest.Status.Review.Comment; comment != "" {
+		message = fmt.Sprintf("%s: %s", message, comment)
+	}
+	r.Recorder.Event(sudoRequest, "Normal", "Reviewed", utils.FormatEventMessage(message, requestId))
+	return nil
+}
+
+// revokeRequest ends a revoked SudoRequest early: its child resources are marked as revoked, so that their
+// bindings are cleaned up right away, and the request moves to the terminal Revoked state.
+func (r *SudoRequestReconciler) revokeRequest(ctx context.Context, sudoRequest *v1.SudoRequest, revocation *v1.Revocation, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	for _, child := range sudoRequest.Status.ChildResource {
+		var temporaryRBAC v1.TemporaryRBAC
+		if err := r.Get(ctx, client.ObjectKey{Name: child.Name, Namespace: child.Namespace}, &temporaryRBAC); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		temporaryRBAC.Spec.Revoked = true
+		if err := r.Update(ctx, &temporaryRBAC); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to revoke TemporaryRBAC", requestId, "child", child)
+			return ctrl.Result{}, err
+		}
+	}
+
+	sudoRequest.Status.State = "Revoked"
+	sudoRequest.Status.Revocation = &v1.Revocation{}
+	revocation.DeepCopyInto(sudoRequest.Status.Revocation)
+	if err := r.Status().Update(ctx, sudoRequest); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to update SudoRequest status to Revoked", requestId)
+		return ctrl.Result{}, err
+	}
+
+	revokedBy := fmt.Sprintf("'%s'", revocation.RevokedBy.Username)
+	if revocation.Lockdown != "" {
+		revokedBy = fmt.Sprintf("lockdown '%s'", revocation.Lockdown)
+	}
+	message := fmt.Sprintf("SudoRequest of user '%s' was revoked by %s", sudoRequest.Spec.Requester.Username, revokedBy)
+	if revocation.Reason != "" {
+		message = fmt.Sprintf("%s: %s", message, revocation.Reason)
+	}
+	r.Recorder.Event(sudoRequest, "Normal", "Revoked", utils.FormatEventMessage(message, requestId))
+	utils.LogInfoUID(logger, "SudoRequest was revoked", requestId, "revokedBy", revocation.RevokedBy.Username, "lockdown", revocation.Lockdown, "reason", revocation.Reason)
+	return ctrl.Result{}, nil
+}
+
+func (r *SudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx context.Context, sudoRequest *v1.SudoRequest, namespaces []string, sudoPolicies []v1.SudoPolicy, requester string, logger logr.Logger, requestId string) (ctrl.Result, error) {
+	var childResources []v1.ChildResource
+	duration := r.grantDuration(sudoRequest, sudoPolicies, requestId)
+
+	// Resolve the roles of every policy before any access is granted
+	grants := make([]utils.PolicyGrant, len(sudoPolicies))
+	for i := range sudoPolicies {
+		grant, err := utils.ResolveGrant(ctx, r.Client, sudoPolicies[i].Spec)
+		if err != nil {
+			return r.errorRequest(ctx, err, sudoRequest, fmt.Sprintf("Failed to resolve the roles of policy '%s': %v", sudoPolicies[i].Name, err), requestId)
+		}
+		grants[i] = grant
+	}
+
+	for i, sudoPolicy := range sudoPolicies {
+		for _, namespace := range namespaces {
+			grant, ok := grants[i].InNamespace(namespace)
+			if !ok {
+				utils.LogInfoUID(logger, "Access profile grants no role in namespace, skipping", requestId, "policy", sudoPolicy.Name, "namespace", namespace)
+				continue
+			}
+			grant, err := utils.RestrictGrant(ctx, r.Client, grant.Narrow(sudoRequest.Spec.Rules), sudoRequest.Spec.ResourceNames, namespace)
+			if err != nil {
+				r.deleteChildResources(ctx, childResources, requestId)
+				return r.errorRequest(ctx, err, sudoRequest, fmt.Sprintf("Failed to restrict the grant of policy '%s' to the requested resource names: %v", sudoPolicy.Name, err), requestId)
+			}
+			temporaryRBAC := &v1.TemporaryRBAC{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      utils.GenerateTempRBACName(rbacv1.Subject{Kind: "User", Name: requester}, sudoPolicy.Name, sudoRequest.Status.RequestID),
+					Namespace: namespace,
+					Labels: map[string]string{
+						"tarbac.io/policy":      sudoPolicy.Name,
+						"tarbac.io/policy-kind": "SudoPolicy",
+					},
+					Annotations: utils.ReferenceAnnotations(sudoRequest.Spec.Justification, sudoRequest.Spec.Ticket),
+				},
+				Spec: v1.TemporaryRBACSpec{
+					Subjects: []rbacv1.Subject{
+						{
+							Kind: "User",
+							Name: requester,
+						},
+					},
+					RoleRef:   grant.RoleRef,
+					Roles:     grant.Roles,
+					Rules:     grant.Rules,
+					Duration:  duration,
+					StartTime: sudoRequest.Spec.StartTime,
+				},
+			}
+
+			// A bundle is granted all at once, so the TemporaryRBACs already created are removed when one fails
+			// and the request is retried
+			if err := controllerutil.SetControllerReference(sudoRequest, temporaryRBAC, r.Scheme); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to set OwnerReference on TemporaryRBAC", requestId)
+				r.deleteChildResources(ctx, childResources, requestId)
+				return ctrl.Result{}, err
+			}
+
+			// A TemporaryRBAC may already exist when the status update failed after it was created
+			if err := r.Client.Create(ctx, temporaryRBAC); err != nil && !apierrors.IsAlreadyExists(err) {
+				utils.LogErrorUID(logger, err, "Failed to create TemporaryRBAC", requestId)
+				r.deleteChildResources(ctx, childResources, requestId)
+				return ctrl.Result{}, err
+			}
+
+			utils.LogInfoUID(logger, "TemporaryRBAC created successfully", requestId, "temporaryRBAC", temporaryRBAC.Name, "namespace", temporaryRBAC.Namespace)
+
+			childResources = append(childResources, v1.ChildResource{
+				APIVersion: "tarbac.io/v1",
+				Kind:       "TemporaryRBAC",
+				Name:       temporaryRBAC.Name,
+				Namespace:  namespace,
+			})
+		}
 	}
 
 	sudoRequest.Status.State = "Approved"
@@ -288,15 +1036,73 @@ func (r *SudoRequestReconciler) createTemporaryRBACsForNamespaces(ctx context.Co
 		utils.LogErrorUID(logger, err, "Failed to update SudoRequest status with TemporaryRBAC details", requestId)
 		return ctrl.Result{}, err
 	}
-	utils.LogInfoUID(logger, "Successfully updated SudoRequest status with TemporaryRBAC details, requeuing while waiting for child resource creation", requestId)
-	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	utils.LogInfoUID(logger, "Successfully updated SudoRequest status with TemporaryRBAC details, waiting for child resource creation", requestId)
+	return ctrl.Result{}, nil
+}
+
+// deleteChildResources removes the TemporaryRBACs created for a grant that could not be completed.
+func (r *SudoRequestReconciler) deleteChildResources(ctx context.Context, childResources []v1.ChildResource, requestId string) {
+	logger := log.FromContext(ctx)
+	for _, childResource := range childResources {
+		temporaryRBAC := &v1.TemporaryRBAC{ObjectMeta: metav1.ObjectMeta{Name: childResource.Name, Namespace: childResource.Namespace}}
+		if err := r.Delete(ctx, temporaryRBAC); err != nil && !apierrors.IsNotFound(err) {
+			utils.LogErrorUID(logger, err, "Failed to delete TemporaryRBAC of incomplete grant", requestId, "temporaryRBAC", childResource.Name, "namespace", childResource.Namespace)
+		}
+	}
+}
+
+// requestsForLockdown enqueues the pending, queued and approved SudoRequests when a lockdown is created, changed or lifted
+func (r *SudoRequestReconciler) requestsForLockdown(ctx context.Context, lockdown client.Object) []reconcile.Request {
+	var sudoRequests v1.SudoRequestList
+	if err := r.List(ctx, &sudoRequests); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list SudoRequests for lockdown", "lockdown", lockdown.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range sudoRequests.Items {
+		if item.Status.State == "Pending" || item.Status.State == "Queued" || item.Status.State == "Approved" {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace}})
+		}
+	}
+	return requests
+}
+
+// queuedRequestsForGrant enqueues the SudoRequests queued under the policy of a TemporaryRBAC when it changes,
+// so that they are granted as soon as it expires or is revoked
+func (r *SudoRequestReconciler) queuedRequestsForGrant(ctx context.Context, temporaryRBAC client.Object) []reconcile.Request {
+	policy := temporaryRBAC.GetLabels()["tarbac.io/policy"]
+	if policy == "" {
+		return nil
+	}
+	queued, err := utils.QueuedSudoRequests(ctx, r.Client, temporaryRBAC.GetNamespace(), policy)
+	if err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list queued SudoRequests for TemporaryRBAC", "temporaryRBAC", temporaryRBAC.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range queued {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: temporaryRBAC.GetNamespace()}})
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SudoRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Scheme = mgr.GetScheme()                                    // Initialize the Scheme field
 	r.Recorder = mgr.GetEventRecorderFor("SudoRequestController") // Properly initialize Recorder
+
+	// Index requests by requester so that quotas can count the previous requests of a user
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.SudoRequest{}, utils.RequesterIndex, utils.IndexRequester); err != nil {
+		return err
+	}
+	// Index requests by policy so that concurrency limits can count the active grants of a policy
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.SudoRequest{}, utils.PolicyIndex, utils.IndexPolicy); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.SudoRequest{}).
+		Owns(&v1.TemporaryRBAC{}).
+		Watches(&v1.TemporaryRBAC{}, handler.EnqueueRequestsFromMapFunc(r.queuedRequestsForGrant)).
+		Watches(&v1.Lockdown{}, handler.EnqueueRequestsFromMapFunc(r.requestsForLockdown)).
 		Complete(r)
 }
@@ -11,11 +11,14 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 func AddToScheme(scheme *runtime.Scheme) error {
@@ -73,6 +76,40 @@ func (r *TemporaryRBACReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
+	// Revoked grants have their bindings cleaned up right away and are never activated again
+	if tempRBAC.Spec.Revoked {
+		if tempRBAC.Status.State != "Revoked" {
+			utils.LogInfoUID(logger, "TemporaryRBAC was revoked, cleaning up associated bindings", requestId)
+			if err := r.cleanupBindings(ctx, &tempRBAC, requestId, "Revoked"); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to clean up bindings for revoked TemporaryRBAC", requestId)
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Revoke the grant when an emergency lockdown covers it, it stays revoked once the lockdown is lifted
+	if tempRBAC.Status.State != "Expired" {
+		lockdown, err := utils.FindLockdown(ctx, r.Client, utils.LockdownScope{
+			Namespaces: []string{tempRBAC.Namespace},
+			Policy:     tempRBAC.Labels["tarbac.io/policy"],
+			PolicyKind: tempRBAC.Labels["tarbac.io/policy-kind"],
+			Users:      utils.SubjectUsers(tempRBAC.Spec.Subjects),
+		})
+		if err != nil {
+			utils.LogErrorUID(logger, err, "Failed to check for lockdowns", requestId)
+			return ctrl.Result{}, err
+		}
+		if lockdown != nil {
+			return r.revokeForLockdown(ctx, &tempRBAC, lockdown, requestId)
+		}
+	}
+
+	// Hold off creating any binding until the scheduled start time arrives
+	if tempRBAC.Spec.StartTime != nil && currentTime.Before(tempRBAC.Spec.StartTime.Time) {
+		return r.scheduleActivation(ctx, &tempRBAC, duration, requestId)
+	}
+
 	if tempRBAC.Status.CreatedAt == nil ||
 		(tempRBAC.Status.ExpiresAt != nil && currentTime.Before(tempRBAC.Status.ExpiresAt.Time)) && currentTime.After(tempRBAC.Status.CreatedAt.Time) {
 		// Ensure bindings are created and status is updated
@@ -100,7 +137,7 @@ func (r *TemporaryRBACReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		utils.LogInfoUID(logger, "TemporaryRBAC expired, cleaning up associated bindings", requestId, "currentTime", currentTime, "expiresAt", tempRBAC.Status.ExpiresAt)
 
 		// Cleanup expired bindings
-		if err := r.cleanupBindings(ctx, &tempRBAC, requestId); err != nil {
+		if err := r.cleanupBindings(ctx, &tempRBAC, requestId, "Expired"); err != nil {
 			utils.LogErrorUID(logger, err, "Failed to clean up bindings for expired TemporaryRBAC", requestId)
 			return ctrl.Result{}, err
 		}
@@ -122,6 +159,53 @@ func (r *TemporaryRBACReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	return ctrl.Result{RequeueAfter: timeUntilExpiration.Truncate(time.Second)}, nil
 }
 
+// scheduleActivation records the scheduled window of a TemporaryRBAC whose start time is in the future
+// and requeues it for when the bindings should be created.
+func (r *TemporaryRBACReconciler) scheduleActivation(ctx context.Context, tempRBAC *tarbacv1.TemporaryRBAC, duration time.Duration, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	startTime := tempRBAC.Spec.StartTime.Time
+
+	if tempRBAC.Status.State != "Scheduled" {
+		tempRBAC.Status.CreatedAt = &metav1.Time{Time: startTime}
+		tempRBAC.Status.ExpiresAt = &metav1.Time{Time: startTime.Add(duration)}
+		tempRBAC.Status.State = "Scheduled"
+		if err := r.Status().Update(ctx, tempRBAC); err != nil {
+			utils.LogErrorUID(logger, err, "Failed to update TemporaryRBAC status with scheduled window", requestId, "startTime", startTime)
+			return ctrl.Result{}, err
+		}
+		eventMessage := fmt.Sprintf("Temporary permissions for %s in namespace %s are scheduled from %s until %s", tempRBAC.Name, tempRBAC.Namespace, tempRBAC.Status.CreatedAt.Format(time.RFC3339), tempRBAC.Status.ExpiresAt.Format(time.RFC3339))
+		r.Recorder.Event(tempRBAC, "Normal", "Scheduled", utils.FormatEventMessage(eventMessage, requestId))
+	}
+
+	timeUntilStart := time.Until(startTime)
+	utils.LogInfoUID(logger, "TemporaryRBAC is scheduled, requeueing for activation", requestId, "timeUntilStart", timeUntilStart)
+	if timeUntilStart < 1*time.Second {
+		return ctrl.Result{RequeueAfter: 1 * time.Second}, nil
+	}
+	return ctrl.Result{RequeueAfter: timeUntilStart}, nil
+}
+
+// revokeForLockdown marks a TemporaryRBAC covered by a lockdown as revoked and cleans up its bindings
+func (r *TemporaryRBACReconciler) revokeForLockdown(ctx context.Context, tempRBAC *tarbacv1.TemporaryRBAC, lockdown *tarbacv1.Lockdown, requestId string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	tempRBAC.Spec.Revoked = true
+	if err := r.Update(ctx, tempRBAC); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to mark TemporaryRBAC as revoked", requestId, "lockdown", lockdown.Name)
+		return ctrl.Result{}, err
+	}
+
+	eventMessage := fmt.Sprintf("Temporary permissions for %s in namespace %s were revoked by lockdown '%s': %s", tempRBAC.Name, tempRBAC.Namespace, lockdown.Name, lockdown.Spec.Reason)
+	r.Recorder.Event(tempRBAC, "Warning", "LockedDown", utils.FormatEventMessage(eventMessage, requestId))
+	utils.LogInfoUID(logger, "TemporaryRBAC revoked by lockdown, cleaning up associated bindings", requestId, "lockdown", lockdown.Name)
+
+	if err := r.cleanupBindings(ctx, tempRBAC, requestId, "Revoked"); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to clean up bindings for revoked TemporaryRBAC", requestId)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
 func (r *TemporaryRBACReconciler) getRequestID(tempRBAC *tarbacv1.TemporaryRBAC) string {
 
 	var requestId string
@@ -135,7 +219,7 @@ func (r *TemporaryRBACReconciler) getRequestID(tempRBAC *tarbacv1.TemporaryRBAC)
 	return requestId
 }
 
-// ensureBindings creates or updates the RoleBinding or ClusterRoleBinding for the TemporaryRBAC resource
+// ensureBindings creates or updates the RoleBindings for the TemporaryRBAC resource, one for each role and subject
 func (r *TemporaryRBACReconciler) ensureBindings(ctx context.Context, tempRBAC *tarbacv1.TemporaryRBAC, requestId string) error {
 	logger := log.FromContext(ctx)
 
@@ -157,21 +241,46 @@ func (r *TemporaryRBACReconciler) ensureBindings(ctx context.Context, tempRBAC *
 
 	var child_resources = []tarbacv1.ChildResource{}
 
-	// Iterate over all subjects and create corresponding bindings
-	for _, subject := range subjects {
+	// Roles of an access profile scoped to another namespace are bound by the TemporaryRBAC of that namespace
+	roles := utils.RolesInNamespace(utils.BindingRoles(&tempRBAC.Spec), tempRBAC.Namespace)
 
-		var binding client.Object
-		// Convert RoleRefWithNamespace to rbacv1.RoleRef
+	// Inline rules are held by an ephemeral Role that lives as long as the grant
+	if len(tempRBAC.Spec.Rules) > 0 {
+		role, err := r.ensureEphemeralRole(ctx, tempRBAC, requestId)
+		if err != nil {
+			return err
+		}
+		roles = append(roles, tarbacv1.RoleRefWithNamespace{RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}})
+		child_resources = append(child_resources, tarbacv1.ChildResource{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "Role",
+			Name:       role.Name,
+			Namespace:  role.Namespace,
+		})
+	}
+
+	if len(roles) == 0 {
+		utils.LogErrorUID(logger, nil, "No roles to bind in the namespace of the TemporaryRBAC", requestId)
+		return fmt.Errorf("no roleRef, roles or rules specified for namespace %s", tempRBAC.Namespace)
+	}
 
+	// Iterate over all roles and subjects and create corresponding bindings
+	for _, role := range roles {
+		// Convert RoleRefWithNamespace to rbacv1.RoleRef
 		roleRef := rbacv1.RoleRef{
-			APIGroup: tempRBAC.Spec.RoleRef.APIGroup,
-			Kind:     tempRBAC.Spec.RoleRef.Kind,
-			Name:     tempRBAC.Spec.RoleRef.Name,
+			APIGroup: role.APIGroup,
+			Kind:     role.Kind,
+			Name:     role.Name,
+		}
+
+		// Both Roles and ClusterRoles are bound by a RoleBinding in the namespace of the TemporaryRBAC
+		if roleRef.Kind != "ClusterRole" && roleRef.Kind != "Role" {
+			utils.LogErrorUID(logger, nil, fmt.Sprintf("unsupported roleRef.kind: %s", roleRef.Kind), requestId)
+			return fmt.Errorf("unsupported roleRef.kind: %s", roleRef.Kind)
 		}
 
-		// Generate the binding based on the RoleRef kind
-		if tempRBAC.Spec.RoleRef.Kind == "ClusterRole" {
-			binding = &rbacv1.RoleBinding{
+		for _, subject := range subjects {
+			binding := &rbacv1.RoleBinding{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      utils.GenerateBindingName(subject, roleRef, requestId),
 					Namespace: tempRBAC.ObjectMeta.Namespace,
@@ -185,46 +294,28 @@ func (r *TemporaryRBACReconciler) ensureBindings(ctx context.Context, tempRBAC *
 			}
 			binding.GetObjectKind().SetGroupVersionKind(rbacv1.SchemeGroupVersion.WithKind("RoleBinding")) // Remove
 
-		} else if tempRBAC.Spec.RoleRef.Kind == "Role" {
-			binding = &rbacv1.RoleBinding{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      utils.GenerateBindingName(subject, roleRef, requestId),
-					Namespace: tempRBAC.ObjectMeta.Namespace,
-					Labels: map[string]string{
-						"tarbac.io/owner":      tempRBAC.Name,
-						"tarbac.io/request-id": requestId,
-					},
-				},
-				Subjects: []rbacv1.Subject{subject},
-				RoleRef:  roleRef,
+			// Set the OwnerReference on the RoleBinding
+			if err := controllerutil.SetControllerReference(tempRBAC, binding, r.Scheme); err != nil {
+				utils.LogErrorUID(logger, err, "Failed to set OwnerReference for RoleBinding", requestId, "RoleBinding", binding)
+				return err
 			}
-			binding.GetObjectKind().SetGroupVersionKind(rbacv1.SchemeGroupVersion.WithKind("RoleBinding")) // Remove
-		} else {
-			utils.LogErrorUID(logger, nil, fmt.Sprintf("unsupported roleRef.kind: %s", tempRBAC.Spec.RoleRef.Kind), requestId)
-			return fmt.Errorf("unsupported roleRef.kind: %s", tempRBAC.Spec.RoleRef.Kind)
-		}
 
-		// Set the OwnerReference on the RoleBinding
-		if err := controllerutil.SetControllerReference(tempRBAC, binding, r.Scheme); err != nil {
-			utils.LogErrorUID(logger, err, "Failed to set OwnerReference for RoleBinding", requestId, "RoleBinding", binding)
-			return err
-		}
+			// Attempt to create the binding
+			if err := r.Client.Create(ctx, binding); err != nil && !apierrors.IsAlreadyExists(err) {
+				utils.LogErrorUID(logger, err, "Failed to create binding", requestId, "RoleBinding", binding)
+				return err
+			}
 
-		// Attempt to create the binding
-		if err := r.Client.Create(ctx, binding); err != nil && !apierrors.IsAlreadyExists(err) {
-			utils.LogErrorUID(logger, err, "Failed to create binding", requestId, "RoleBinding", binding)
-			return err
+			child_resources = append(child_resources, tarbacv1.ChildResource{
+				APIVersion: rbacv1.SchemeGroupVersion.String(),
+				Kind:       binding.GetObjectKind().GroupVersionKind().Kind,
+				Name:       binding.GetName(),
+				Namespace:  binding.GetNamespace(),
+			})
 		}
-
-		child_resources = append(child_resources, tarbacv1.ChildResource{
-			APIVersion: rbacv1.SchemeGroupVersion.String(),
-			Kind:       binding.GetObjectKind().GroupVersionKind().Kind,
-			Name:       binding.GetName(),
-			Namespace:  binding.GetNamespace(),
-		})
 	}
 
-	// Update the status with the last created child resource
+	// Update the status with all the created child resources
 	tempRBAC.Status.ChildResource = child_resources
 	tempRBAC.Status.State = "Created"
 
@@ -241,8 +332,37 @@ func (r *TemporaryRBACReconciler) ensureBindings(ctx context.Context, tempRBAC *
 	return nil
 }
 
-// cleanupBindings deletes the RoleBinding or ClusterRoleBinding associated with the TemporaryRBAC resource
-func (r *TemporaryRBACReconciler) cleanupBindings(ctx context.Context, tempRBAC *tarbacv1.TemporaryRBAC, requestId string) error {
+// ensureEphemeralRole creates the Role holding the inline rules of the TemporaryRBAC, owned by it
+func (r *TemporaryRBACReconciler) ensureEphemeralRole(ctx context.Context, tempRBAC *tarbacv1.TemporaryRBAC, requestId string) (*rbacv1.Role, error) {
+	logger := log.FromContext(ctx)
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      utils.EphemeralRoleName(tempRBAC.Name),
+			Namespace: tempRBAC.Namespace,
+			Labels: map[string]string{
+				"tarbac.io/owner":      tempRBAC.Name,
+				"tarbac.io/request-id": requestId,
+			},
+		},
+		Rules: tempRBAC.Spec.Rules,
+	}
+
+	if err := controllerutil.SetControllerReference(tempRBAC, role, r.Scheme); err != nil {
+		utils.LogErrorUID(logger, err, "Failed to set OwnerReference for Role", requestId, "Role", role.Name)
+		return nil, err
+	}
+
+	if err := r.Client.Create(ctx, role); err != nil && !apierrors.IsAlreadyExists(err) {
+		utils.LogErrorUID(logger, err, "Failed to create ephemeral Role", requestId, "Role", role.Name)
+		return nil, err
+	}
+	return role, nil
+}
+
+// cleanupBindings deletes the RoleBindings and the ephemeral Role associated with the TemporaryRBAC resource
+// and moves it to the given state, Expired or Revoked, once no binding remains
+func (r *TemporaryRBACReconciler) cleanupBindings(ctx context.Context, tempRBAC *tarbacv1.TemporaryRBAC, requestId string, state string) error {
 	logger := log.FromContext(ctx)
 	var remainingChildResources []tarbacv1.ChildResource
 
@@ -275,6 +395,20 @@ func (r *TemporaryRBACReconciler) cleanupBindings(ctx context.Context, tempRBAC
 				// r.Recorder.Event(tempRBAC, "Normal", "PermissionsRevoked", fmt.Sprintf("Temporary permissions were revoked in namespace %s [UID: %s]", tempRBAC.ObjectMeta.Namespace, requestId))
 				eventMessage := fmt.Sprintf("Temporary permissions were revoked for %s in namespace %s", tempRBAC.Name, tempRBAC.Namespace)
 				r.Recorder.Event(tempRBAC, "Normal", "PermissionsRevoked", utils.FormatEventMessage(eventMessage, requestId))
+			case "Role":
+				// Delete the ephemeral Role of inline rules, its bindings are gone with it
+				err := r.Client.Delete(ctx, &rbacv1.Role{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      child.Name,
+						Namespace: child.Namespace,
+					},
+				})
+				if err != nil && !apierrors.IsNotFound(err) {
+					utils.LogErrorUID(logger, err, "Failed to delete Role", requestId, "kind", child.Kind, "name", child.Name, "namespace", child.Namespace)
+					remainingChildResources = append(remainingChildResources, child)
+					continue
+				}
+				utils.LogInfoUID(logger, "Successfully deleted Role", requestId, "kind", child.Kind, "name", child.Name, "namespace", child.Namespace)
 			default:
 				utils.LogErrorUID(logger, nil, "Unsupported child resource kind", requestId, "kind", child.Kind)
 				remainingChildResources = append(remainingChildResources, child)
@@ -291,7 +425,7 @@ func (r *TemporaryRBACReconciler) cleanupBindings(ctx context.Context, tempRBAC
 
 	// Update the state if no child resources remain
 	if tempRBAC.Status.ChildResource == nil {
-		tempRBAC.Status.State = "Expired"
+		tempRBAC.Status.State = state
 	}
 
 	// Check DeletionPolicy
@@ -341,6 +475,9 @@ func (r *TemporaryRBACReconciler) fetchAndSetRequestID(ctx context.Context, temp
 					continue
 				}
 				ownerRequestID = sudoRequest.Status.RequestID
+			case "RecurringSudoRequest", "ClusterRecurringSudoRequest":
+				// Every occurrence of a recurring request is tracked under its own RequestID
+				ownerRequestID = string(tempRBAC.ObjectMeta.UID)
 			default:
 				utils.LogInfoUID(logger, "Unsupported owner reference kind, skipping", requestId, "ownerRef", ownerRef.Name, "ownerRefKind", ownerRef.Kind)
 				continue
@@ -373,10 +510,28 @@ func (r *TemporaryRBACReconciler) fetchAndSetRequestID(ctx context.Context, temp
 	return nil
 }
 
+// temporaryRBACsForLockdown enqueues the active TemporaryRBACs when a lockdown is created, changed or lifted
+func (r *TemporaryRBACReconciler) temporaryRBACsForLockdown(ctx context.Context, lockdown client.Object) []reconcile.Request {
+	var list tarbacv1.TemporaryRBACList
+	if err := r.List(ctx, &list); err != nil {
+		utils.LogError(log.FromContext(ctx), err, "Failed to list TemporaryRBACs for lockdown", "lockdown", lockdown.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, item := range list.Items {
+		if item.Status.State == "Expired" || item.Status.State == "Revoked" {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace}})
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager
 func (r *TemporaryRBACReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("TemporaryRBACController")
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&tarbacv1.TemporaryRBAC{}).
+		Watches(&tarbacv1.Lockdown{}, handler.EnqueueRequestsFromMapFunc(r.temporaryRBACsForLockdown)).
 		Complete(r)
 }
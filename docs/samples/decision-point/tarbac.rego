@@ -0,0 +1,14 @@
+package tarbac
+
+import rego.v1
+
+# Queried at http://<opa>:8181/v1/data/tarbac/decision
+default decision := {"allow": false, "reason": "requests to cluster-admin require the sre group"}
+
+decision := {"allow": true} if {
+	input.roleRef.name != "cluster-admin"
+}
+
+decision := {"allow": true} if {
+	"sre" in input.requester.groups
+}
@@ -4,11 +4,14 @@ import (
 	"flag"
 	"os"
     "fmt"
+	"time"
 
 	tarbacv1 "github.com/guybal/tarbac/api/v1" // Adjust to match your actual module path
-// 	utils "github.com/guybal/tarbac/utils"
+	utils "github.com/guybal/tarbac/utils"
 	sudorequest "github.com/guybal/tarbac/controllers/sudorequest"
 	clustersudorequest "github.com/guybal/tarbac/controllers/clustersudorequest"
+	recurringsudorequest "github.com/guybal/tarbac/controllers/recurringsudorequest"
+	clusterrecurringsudorequest "github.com/guybal/tarbac/controllers/clusterrecurringsudorequest"
     temporaryrbac "github.com/guybal/tarbac/controllers/temporaryrbac"
 	clustertemporaryrbac "github.com/guybal/tarbac/controllers/clustertemporaryrbac"
     sudopolicy "github.com/guybal/tarbac/controllers/sudopolicy"
@@ -26,10 +29,28 @@ import (
 
 func main() {
 	var enableLeaderElection bool
+	var decisionPointURL string
+	var decisionPointTimeout time.Duration
+	var decisionPointFailOpen bool
+	var decisionPointCacheTTL time.Duration
+	var notificationURL string
+	var notificationTimeout time.Duration
+	var ticketValidatorURL string
+	var ticketValidatorTimeout time.Duration
+	var ticketValidatorCacheTTL time.Duration
  	//var metricsAddr string
 
 // 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false, "Enable leader election for controller manager.")
+	flag.StringVar(&decisionPointURL, "decision-point-url", "", "URL of an external, OPA compatible, policy decision point queried for every request. Disabled when empty.")
+	flag.DurationVar(&decisionPointTimeout, "decision-point-timeout", 5*time.Second, "Timeout of a single query to the external decision point.")
+	flag.BoolVar(&decisionPointFailOpen, "decision-point-fail-open", false, "Allow requests when the external decision point cannot be reached, instead of rejecting them.")
+	flag.DurationVar(&decisionPointCacheTTL, "decision-point-cache-ttl", 30*time.Second, "How long decisions of the external decision point are cached, 0 disables caching.")
+	flag.StringVar(&notificationURL, "notification-url", "", "URL of a webhook notified with a JSON payload of every break-glass request. Disabled when empty.")
+	flag.DurationVar(&notificationTimeout, "notification-timeout", 5*time.Second, "Timeout of a single notification.")
+	flag.StringVar(&ticketValidatorURL, "ticket-validator-url", "", "URL of an external service confirming that the ticket of a request exists and is open. Disabled when empty.")
+	flag.DurationVar(&ticketValidatorTimeout, "ticket-validator-timeout", 5*time.Second, "Timeout of a single ticket validation.")
+	flag.DurationVar(&ticketValidatorCacheTTL, "ticket-validator-cache-ttl", time.Minute, "How long ticket validations are cached, 0 disables caching.")
 	flag.Parse()
 
     defer func() {
@@ -101,9 +122,33 @@ func main() {
     	os.Exit(1)
     }
 
+    // Set up the optional external policy decision point
+    var decisionClient *utils.DecisionClient
+    if decisionPointURL != "" {
+        decisionClient = utils.NewDecisionClient(decisionPointURL, decisionPointTimeout, decisionPointFailOpen, decisionPointCacheTTL)
+        ctrl.Log.Info("external decision point enabled", "url", decisionPointURL, "failOpen", decisionPointFailOpen)
+    }
+
+    // Set up the optional notification endpoint for break-glass requests
+    var notifier *utils.Notifier
+    if notificationURL != "" {
+        notifier = utils.NewNotifier(notificationURL, notificationTimeout)
+        ctrl.Log.Info("break-glass notifications enabled", "url", notificationURL)
+    }
+
+    // Set up the optional ticket validator
+    var ticketValidator *utils.TicketValidator
+    if ticketValidatorURL != "" {
+        ticketValidator = utils.NewTicketValidator(ticketValidatorURL, ticketValidatorTimeout, ticketValidatorCacheTTL)
+        ctrl.Log.Info("ticket validation enabled", "url", ticketValidatorURL)
+    }
+
     // Add SudoRequestReconciler to the manager
     if err = (&sudorequest.SudoRequestReconciler{
         Client: mgr.GetClient(),
+        DecisionClient: decisionClient,
+        Notifier: notifier,
+        TicketValidator: ticketValidator,
     }).SetupWithManager(mgr); err != nil {
         ctrl.Log.Error(err, "unable to create controller", "controller", "SudoRequest")
         os.Exit(1)
@@ -112,11 +157,34 @@ func main() {
     // Add ClusterSudoRequestReconciler to the manager
     if err = (&clustersudorequest.ClusterSudoRequestReconciler{
     	Client: mgr.GetClient(),
+    	DecisionClient: decisionClient,
+    	Notifier: notifier,
+    	TicketValidator: ticketValidator,
     }).SetupWithManager(mgr); err != nil {
     	ctrl.Log.Error(err, "unable to create controller", "controller", "ClusterSudoRequest")
     	os.Exit(1)
     }
 
+    // Add RecurringSudoRequestReconciler to the manager
+    if err = (&recurringsudorequest.RecurringSudoRequestReconciler{
+        Client: mgr.GetClient(),
+        DecisionClient: decisionClient,
+        TicketValidator: ticketValidator,
+    }).SetupWithManager(mgr); err != nil {
+        ctrl.Log.Error(err, "unable to create controller", "controller", "RecurringSudoRequest")
+        os.Exit(1)
+    }
+
+    // Add ClusterRecurringSudoRequestReconciler to the manager
+    if err = (&clusterrecurringsudorequest.ClusterRecurringSudoRequestReconciler{
+    	Client: mgr.GetClient(),
+    	DecisionClient: decisionClient,
+    	TicketValidator: ticketValidator,
+    }).SetupWithManager(mgr); err != nil {
+    	ctrl.Log.Error(err, "unable to create controller", "controller", "ClusterRecurringSudoRequest")
+    	os.Exit(1)
+    }
+
     if err = (&sudopolicy.SudoPolicyReconciler{
         Client: mgr.GetClient(),
     }).SetupWithManager(mgr); err != nil {
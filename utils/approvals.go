@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+)
+
+// IsApprover reports whether the identity matches one of the approvers, either by user name or by group membership.
+func IsApprover(approvers []v1.ApproverRef, identity v1.UserIdentity) bool {
+	for _, approver := range approvers {
+		switch approver.Kind {
+		case "User":
+			if approver.Name == identity.Username {
+				return true
+			}
+		case "Group":
+			for _, group := range identity.Groups {
+				if group == approver.Name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// AllApprovers returns the policy approvers together with the approvers of every escalation tier.
+func AllApprovers(policySpec v1.SudoPolicySpec) []v1.ApproverRef {
+	approvers := append([]v1.ApproverRef{}, policySpec.Approvers...)
+	for _, tier := range policySpec.Escalations {
+		approvers = append(approvers, tier.Approvers...)
+	}
+	return approvers
+}
+
+// ActiveApprovers returns the approvers eligible at the given time: the policy approvers plus
+// the approvers of every escalation tier whose delay has elapsed since the request was submitted.
+func ActiveApprovers(policySpec v1.SudoPolicySpec, submittedAt time.Time, at time.Time) []v1.ApproverRef {
+	approvers := append([]v1.ApproverRef{}, policySpec.Approvers...)
+	for _, tier := range policySpec.Escalations {
+		after, err := time.ParseDuration(tier.After)
+		if err != nil {
+			continue
+		}
+		if !at.Before(submittedAt.Add(after)) {
+			approvers = append(approvers, tier.Approvers...)
+		}
+	}
+	return approvers
+}
+
+// EscalationLevel returns the number of escalation tiers eligible at the given time,
+// and when the next tier becomes eligible (zero if there is none).
+func EscalationLevel(policySpec v1.SudoPolicySpec, submittedAt time.Time, at time.Time) (int, time.Time) {
+	var level int
+	var next time.Time
+	for _, tier := range policySpec.Escalations {
+		after, err := time.ParseDuration(tier.After)
+		if err != nil {
+			continue
+		}
+		eligibleAt := submittedAt.Add(after)
+		if !at.Before(eligibleAt) {
+			level++
+		} else if next.IsZero() || eligibleAt.Before(next) {
+			next = eligibleAt
+		}
+	}
+	return level, next
+}
+
+// EligibleApprovals filters the approvals recorded on a request down to the ones that count towards the quorum:
+// approvals by approvers eligible at the time of approval, excluding self-approvals by the requester and
+// repeated approvals by the same user.
+func EligibleApprovals(policySpec v1.SudoPolicySpec, approvals []v1.Approval, requester string, submittedAt time.Time) []v1.Approval {
+	var eligible []v1.Approval
+	seen := map[string]bool{}
+	for _, approval := range approvals {
+		username := approval.Approver.Username
+		if username == requester || seen[username] {
+			continue
+		}
+		if IsApprover(ActiveApprovers(policySpec, submittedAt, approval.Timestamp.Time), approval.Approver) {
+			seen[username] = true
+			eligible = append(eligible, approval)
+		}
+	}
+	return eligible
+}
+
+// RequiredApprovals returns the quorum of a policy, defaulting to a single approval.
+func RequiredApprovals(policySpec v1.SudoPolicySpec) int {
+	if policySpec.RequiredApprovals < 1 {
+		return 1
+	}
+	return policySpec.RequiredApprovals
+}
+
+// ApprovalsSince returns the approvals given at or after the given time.
+func ApprovalsSince(approvals []v1.Approval, since time.Time) []v1.Approval {
+	var recent []v1.Approval
+	for _, approval := range approvals {
+		if !approval.Timestamp.Time.Before(since) {
+			recent = append(recent, approval)
+		}
+	}
+	return recent
+}
+
+// HasApproval reports whether an approval is already among the recorded approvals.
+func HasApproval(approvals []v1.Approval, approval v1.Approval) bool {
+	for _, recorded := range approvals {
+		if recorded.Approver.Username == approval.Approver.Username && recorded.Timestamp.Equal(&approval.Timestamp) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PendingReviewLabel marks break-glass requests that are awaiting review, so that they can be listed with
+// kubectl get sudorequests,clustersudorequests -A -l tarbac.io/pending-review=true. The webhook keeps it
+// until the review is recorded.
+const PendingReviewLabel = "tarbac.io/pending-review"
+
+// BreakGlassReviewers returns who may review the break-glass requests of a policy, defaulting to its approvers.
+func BreakGlassReviewers(policySpec v1.SudoPolicySpec) []v1.ApproverRef {
+	if policySpec.BreakGlass != nil && len(policySpec.BreakGlass.Reviewers) > 0 {
+		return policySpec.BreakGlass.Reviewers
+	}
+	return policySpec.Approvers
+}
+
+// MaxUnreviewedBreakGlass returns how many unreviewed break-glass requests a user may have, defaulting to 1.
+func MaxUnreviewedBreakGlass(policySpec v1.SudoPolicySpec) int {
+	if policySpec.BreakGlass == nil || policySpec.BreakGlass.MaxUnreviewed < 1 {
+		return 1
+	}
+	return policySpec.BreakGlass.MaxUnreviewed
+}
+
+// EligibleReview returns the first review given by a reviewer of the policy other than the requester,
+// or nil when there is none.
+func EligibleReview(policySpec v1.SudoPolicySpec, reviews []v1.Review, requester string) *v1.Review {
+	reviewers := BreakGlassReviewers(policySpec)
+	for i := range reviews {
+		if reviews[i].Reviewer.Username != requester && IsApprover(reviewers, reviews[i].Reviewer) {
+			return &reviews[i]
+		}
+	}
+	return nil
+}
+
+// CountUnreviewedBreakGlass counts the SudoRequests and ClusterSudoRequests of a user that are awaiting review.
+// Requests are counted on their status, which only the controller sets, rather than on the PendingReviewLabel.
+func CountUnreviewedBreakGlass(ctx context.Context, c client.Reader, username string) (int, error) {
+	selector := client.MatchingFields{RequesterIndex: username}
+	var count int
+
+	var sudoRequests v1.SudoRequestList
+	if err := c.List(ctx, &sudoRequests, selector); err != nil {
+		return 0, fmt.Errorf("failed to list SudoRequests awaiting review: %v", err)
+	}
+	for _, sudoRequest := range sudoRequests.Items {
+		if sudoRequest.Status.ReviewState == "AwaitingReview" {
+			count++
+		}
+	}
+
+	var clusterSudoRequests v1.ClusterSudoRequestList
+	if err := c.List(ctx, &clusterSudoRequests, selector); err != nil {
+		return 0, fmt.Errorf("failed to list ClusterSudoRequests awaiting review: %v", err)
+	}
+	for _, clusterSudoRequest := range clusterSudoRequests.Items {
+		if clusterSudoRequest.Status.ReviewState == "AwaitingReview" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CheckBreakGlass returns why a break-glass request may not be granted under the policy, or an empty string
+// when it may. Users with too many break-glass requests awaiting review cannot break the glass again.
+func CheckBreakGlass(ctx context.Context, c client.Reader, policySpec v1.SudoPolicySpec, justification string, requester string) (string, error) {
+	if policySpec.BreakGlass == nil {
+		return "Policy does not allow break-glass requests", nil
+	}
+	if strings.TrimSpace(justification) == "" {
+		return "A justification is required for break-glass requests", nil
+	}
+	unreviewed, err := CountUnreviewedBreakGlass(ctx, c, requester)
+	if err != nil {
+		return "", err
+	}
+	if limit := MaxUnreviewedBreakGlass(policySpec); unreviewed >= limit {
+		return fmt.Sprintf("User has %d break-glass requests awaiting review, the policy allows at most %d", unreviewed, limit), nil
+	}
+	return "", nil
+}
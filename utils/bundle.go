@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+)
+
+// RequestPolicies returns the names of all the policies a request is enforced under: the policies of a
+// bundle, or the single policy named in the spec or selected by the controller.
+func RequestPolicies(spec *v1.SudoRequestSpec, status *v1.SudoRequestStatus) []string {
+	if len(spec.Policies) > 0 {
+		return spec.Policies
+	}
+	if policy := RequestPolicy(spec, status); policy != "" {
+		return []string{policy}
+	}
+	return nil
+}
+
+// HasPolicy reports whether a request is enforced under the given policy.
+func HasPolicy(spec *v1.SudoRequestSpec, status *v1.SudoRequestStatus, policy string) bool {
+	for _, name := range RequestPolicies(spec, status) {
+		if name == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribePolicies formats the policies of a request for event messages, e.g. "policy 'view'" or
+// "policies 'view', 'payments-edit'".
+func DescribePolicies(names []string) string {
+	if len(names) == 1 {
+		return fmt.Sprintf("policy '%s'", names[0])
+	}
+	return fmt.Sprintf("policies '%s'", strings.Join(names, "', '"))
+}
+
+// ValidateBundle checks that the policies of a bundle are named once each.
+func ValidateBundle(policies []string) error {
+	seen := map[string]bool{}
+	for _, policy := range policies {
+		if policy == "" {
+			return fmt.Errorf("policy names must not be empty")
+		}
+		if seen[policy] {
+			return fmt.Errorf("policy '%s' is referenced more than once", policy)
+		}
+		seen[policy] = true
+	}
+	return nil
+}
+
+// ShortestDuration returns the shortest of the given durations, so that the grants of all the policies of a
+// bundle share one expiry. Durations that do not parse are ignored.
+func ShortestDuration(durations []string) string {
+	var shortest string
+	var shortestDuration time.Duration
+	for _, duration := range durations {
+		parsed, err := time.ParseDuration(duration)
+		if err != nil {
+			continue
+		}
+		if shortest == "" || parsed < shortestDuration {
+			shortest = duration
+			shortestDuration = parsed
+		}
+	}
+	return shortest
+}
+
+// EligibleBundleReview returns the first review of a break-glass request given by a reviewer of every one of
+// its policies, or nil when there is none.
+func EligibleBundleReview(policySpecs []v1.SudoPolicySpec, reviews []v1.Review, requester string) *v1.Review {
+	for i := range reviews {
+		eligible := true
+		for _, policySpec := range policySpecs {
+			if EligibleReview(policySpec, reviews[i:i+1], requester) == nil {
+				eligible = false
+				break
+			}
+		}
+		if eligible {
+			return &reviews[i]
+		}
+	}
+	return nil
+}
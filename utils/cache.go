@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type cacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// ttlCache caches the answers of external services, such as the decision point and the ticket validator, by the
+// hash of the input sent to them. The zero value is an empty cache ready to use.
+type ttlCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry[T]
+}
+
+// cacheKey returns the key an input sent to an external service is cached under.
+func cacheKey(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the value cached under the key, unless it has expired.
+func (c *ttlCache[T]) get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set caches the value under the key for the given time, dropping expired entries. Nothing is cached when the
+// time is not positive.
+func (c *ttlCache[T]) set(key string, value T, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]cacheEntry[T]{}
+	}
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry[T]{value: value, expiresAt: now.Add(ttl)}
+}
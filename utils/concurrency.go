@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PolicyIndex is the field index of SudoRequests and ClusterSudoRequests by the name of their policy
+const PolicyIndex = "spec.policy"
+
+// IndexPolicy returns the policy names of a SudoRequest or ClusterSudoRequest for the PolicyIndex,
+// which are the selected policy for requests that asked for a role and every policy of a bundle.
+func IndexPolicy(obj client.Object) []string {
+	switch request := obj.(type) {
+	case *v1.SudoRequest:
+		return RequestPolicies(&request.Spec, &request.Status)
+	case *v1.ClusterSudoRequest:
+		return RequestPolicies(&request.Spec, &request.Status)
+	}
+	return nil
+}
+
+// ActiveRequest is an approved request whose grant has not ended yet.
+type ActiveRequest struct {
+	Name      string
+	Requester string
+	ExpiresAt *metav1.Time // Nil until the grant of the request has been created
+}
+
+// HasConcurrencyLimits reports whether a policy limits the grants active at once or handles duplicates.
+func HasConcurrencyLimits(policySpec v1.SudoPolicySpec) bool {
+	return policySpec.MaxActive > 0 || policySpec.MaxActivePerUser > 0 || policySpec.OnDuplicate == "Reject" || policySpec.OnDuplicate == "Merge"
+}
+
+// ValidateConcurrencyLimits checks that the concurrency limits and duplicate handling of a policy are well formed.
+func ValidateConcurrencyLimits(policySpec v1.SudoPolicySpec) error {
+	if policySpec.MaxActive < 0 {
+		return fmt.Errorf("maxActive must not be negative: %d", policySpec.MaxActive)
+	}
+	if policySpec.MaxActivePerUser < 0 {
+		return fmt.Errorf("maxActivePerUser must not be negative: %d", policySpec.MaxActivePerUser)
+	}
+	switch policySpec.OnDuplicate {
+	case "", "Allow", "Reject", "Merge":
+	default:
+		return fmt.Errorf("invalid onDuplicate '%s', expected Allow, Reject or Merge", policySpec.OnDuplicate)
+	}
+	switch policySpec.OnLimitReached {
+	case "", "Reject":
+	case "Queue":
+		if policySpec.MaxActive == 0 && policySpec.MaxActivePerUser == 0 {
+			return fmt.Errorf("onLimitReached Queue requires maxActive or maxActivePerUser")
+		}
+	default:
+		return fmt.Errorf("invalid onLimitReached '%s', expected Reject or Queue", policySpec.OnLimitReached)
+	}
+	return nil
+}
+
+// activeRequest returns the active grant of a request, if it has one at the given time.
+func activeRequest(name string, spec *v1.SudoRequestSpec, status *v1.SudoRequestStatus, now time.Time) (ActiveRequest, bool) {
+	if status.State != "Approved" || spec.Revocation != nil {
+		return ActiveRequest{}, false
+	}
+	if status.ExpiresAt != nil && !now.Before(status.ExpiresAt.Time) {
+		return ActiveRequest{}, false
+	}
+	return ActiveRequest{Name: name, Requester: spec.Requester.Username, ExpiresAt: status.ExpiresAt}, true
+}
+
+// ActiveSudoRequests returns the other SudoRequests with an active grant under a policy.
+func ActiveSudoRequests(ctx context.Context, c client.Reader, namespace string, policy string, exclude string) ([]ActiveRequest, error) {
+	var sudoRequests v1.SudoRequestList
+	if err := c.List(ctx, &sudoRequests, client.InNamespace(namespace), client.MatchingFields{PolicyIndex: policy}); err != nil {
+		return nil, fmt.Errorf("failed to list SudoRequests of policy '%s': %v", policy, err)
+	}
+	now := time.Now()
+	var active []ActiveRequest
+	for i := range sudoRequests.Items {
+		sudoRequest := &sudoRequests.Items[i]
+		if sudoRequest.Name == exclude {
+			continue
+		}
+		if request, ok := activeRequest(sudoRequest.Name, &sudoRequest.Spec, &sudoRequest.Status, now); ok {
+			active = append(active, request)
+		}
+	}
+	return active, nil
+}
+
+// ActiveClusterSudoRequests returns the other ClusterSudoRequests with an active grant under a policy.
+func ActiveClusterSudoRequests(ctx context.Context, c client.Reader, policy string, exclude string) ([]ActiveRequest, error) {
+	var clusterSudoRequests v1.ClusterSudoRequestList
+	if err := c.List(ctx, &clusterSudoRequests, client.MatchingFields{PolicyIndex: policy}); err != nil {
+		return nil, fmt.Errorf("failed to list ClusterSudoRequests of policy '%s': %v", policy, err)
+	}
+	now := time.Now()
+	var active []ActiveRequest
+	for i := range clusterSudoRequests.Items {
+		clusterSudoRequest := &clusterSudoRequests.Items[i]
+		if clusterSudoRequest.Name == exclude {
+			continue
+		}
+		if request, ok := activeRequest(clusterSudoRequest.Name, &clusterSudoRequest.Spec, &clusterSudoRequest.Status, now); ok {
+			active = append(active, request)
+		}
+	}
+	return active, nil
+}
+
+// FindDuplicate returns the active request of the same user that is still granted at the given activation
+// time, preferring the one that expires last, or nil when there is none.
+func FindDuplicate(active []ActiveRequest, requester string, activation time.Time) *ActiveRequest {
+	var duplicate *ActiveRequest
+	for i := range active {
+		request := &active[i]
+		if request.Requester != requester {
+			continue
+		}
+		if request.ExpiresAt != nil && !activation.Before(request.ExpiresAt.Time) {
+			continue
+		}
+		if duplicate == nil || (duplicate.ExpiresAt != nil && (request.ExpiresAt == nil || request.ExpiresAt.After(duplicate.ExpiresAt.Time))) {
+			duplicate = request
+		}
+	}
+	return duplicate
+}
+
+// CheckConcurrency checks whether one more grant for the user fits the concurrency limits of the policy,
+// and returns why it does not, or an empty string when it does.
+func CheckConcurrency(policySpec v1.SudoPolicySpec, active []ActiveRequest, requester string) string {
+	if policySpec.MaxActivePerUser > 0 {
+		var count int
+		for _, request := range active {
+			if request.Requester == requester {
+				count++
+			}
+		}
+		if count >= policySpec.MaxActivePerUser {
+			return fmt.Sprintf("user '%s' already holds %d active grants, the maximum per user is %d", requester, count, policySpec.MaxActivePerUser)
+		}
+	}
+	if policySpec.MaxActive > 0 && len(active) >= policySpec.MaxActive {
+		return fmt.Sprintf("%d grants are already active, the maximum is %d", len(active), policySpec.MaxActive)
+	}
+	return ""
+}
+
+// QueuedRequest is a request waiting in the queue of a policy for a grant to end.
+type QueuedRequest struct {
+	Name      string
+	Requester string
+	QueuedAt  time.Time
+}
+
+// queuedRequest returns the queue entry of a request, if it is queued.
+func queuedRequest(name string, creationTimestamp metav1.Time, spec *v1.SudoRequestSpec, status *v1.SudoRequestStatus) (QueuedRequest, bool) {
+	if status.State != "Queued" || spec.Revocation != nil {
+		return QueuedRequest{}, false
+	}
+	queuedAt := creationTimestamp.Time
+	if status.QueuedAt != nil {
+		queuedAt = status.QueuedAt.Time
+	}
+	return QueuedRequest{Name: name, Requester: spec.Requester.Username, QueuedAt: queuedAt}, true
+}
+
+// sortQueue orders queued requests first in, first out, by name for requests queued at the same time.
+func sortQueue(queued []QueuedRequest) {
+	sort.Slice(queued, func(i, j int) bool {
+		if !queued[i].QueuedAt.Equal(queued[j].QueuedAt) {
+			return queued[i].QueuedAt.Before(queued[j].QueuedAt)
+		}
+		return queued[i].Name < queued[j].Name
+	})
+}
+
+// QueuedSudoRequests returns the SudoRequests waiting in the queue of a policy, in the order they are granted.
+func QueuedSudoRequests(ctx context.Context, c client.Reader, namespace string, policy string) ([]QueuedRequest, error) {
+	var sudoRequests v1.SudoRequestList
+	if err := c.List(ctx, &sudoRequests, client.InNamespace(namespace), client.MatchingFields{PolicyIndex: policy}); err != nil {
+		return nil, fmt.Errorf("failed to list SudoRequests of policy '%s': %v", policy, err)
+	}
+	var queued []QueuedRequest
+	for i := range sudoRequests.Items {
+		sudoRequest := &sudoRequests.Items[i]
+		if request, ok := queuedRequest(sudoRequest.Name, sudoRequest.CreationTimestamp, &sudoRequest.Spec, &sudoRequest.Status); ok {
+			queued = append(queued, request)
+		}
+	}
+	sortQueue(queued)
+	return queued, nil
+}
+
+// QueuedClusterSudoRequests returns the ClusterSudoRequests waiting in the queue of a policy, in the order they are granted.
+func QueuedClusterSudoRequests(ctx context.Context, c client.Reader, policy string) ([]QueuedRequest, error) {
+	var clusterSudoRequests v1.ClusterSudoRequestList
+	if err := c.List(ctx, &clusterSudoRequests, client.MatchingFields{PolicyIndex: policy}); err != nil {
+		return nil, fmt.Errorf("failed to list ClusterSudoRequests of policy '%s': %v", policy, err)
+	}
+	var queued []QueuedRequest
+	for i := range clusterSudoRequests.Items {
+		clusterSudoRequest := &clusterSudoRequests.Items[i]
+		if request, ok := queuedRequest(clusterSudoRequest.Name, clusterSudoRequest.CreationTimestamp, &clusterSudoRequest.Spec, &clusterSudoRequest.Status); ok {
+			queued = append(queued, request)
+		}
+	}
+	sortQueue(queued)
+	return queued, nil
+}
+
+// RequestsAhead returns the queued requests ahead of the named request as active requests without expiration,
+// so that they are counted against the concurrency limits before it. A request that is not queued yet is
+// behind all of them.
+func RequestsAhead(queued []QueuedRequest, name string) []ActiveRequest {
+	var ahead []ActiveRequest
+	for _, request := range queued {
+		if request.Name == name {
+			break
+		}
+		ahead = append(ahead, ActiveRequest{Name: request.Name, Requester: request.Requester})
+	}
+	return ahead
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+)
+
+// ConditionInput holds the values a policy condition is evaluated against.
+type ConditionInput struct {
+	Name       string          // Name of the request
+	Namespace  string          // Namespace of the request, empty for cluster-scoped requests
+	Policy     string          // Name of the policy the request refers to
+	Duration   time.Duration   // Requested duration
+	Requester  v1.UserIdentity // Identity of the requester
+	Namespaces []string        // Namespaces the access would be granted in
+	Now        time.Time       // Evaluation time
+}
+
+// newConditionEnv declares the variables available to policy conditions:
+// request, user, namespaces and now.
+func newConditionEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("user", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("namespaces", cel.ListType(cel.StringType)),
+		cel.Variable("now", cel.TimestampType),
+	)
+}
+
+// CompileConditions compiles and type-checks the CEL expressions of policy conditions.
+func CompileConditions(conditions []v1.PolicyCondition) ([]cel.Program, error) {
+	env, err := newConditionEnv()
+	if err != nil {
+		return nil, err
+	}
+	programs := make([]cel.Program, 0, len(conditions))
+	for _, condition := range conditions {
+		ast, issues := env.Compile(condition.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("condition '%s' failed to compile: %v", condition.Name, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			return nil, fmt.Errorf("condition '%s' must evaluate to a bool, got %s", condition.Name, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("condition '%s' failed to compile: %v", condition.Name, err)
+		}
+		programs = append(programs, program)
+	}
+	return programs, nil
+}
+
+// EvaluateConditions evaluates the conditions of a policy against a request and
+// describes the first condition that is not satisfied.
+func EvaluateConditions(policySpec v1.SudoPolicySpec, input ConditionInput) error {
+	programs, err := CompileConditions(policySpec.Conditions)
+	if err != nil {
+		return err
+	}
+
+	extra := map[string]interface{}{}
+	for key, values := range input.Requester.Extra {
+		extra[key] = values
+	}
+	groups := input.Requester.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	namespaces := input.Namespaces
+	if namespaces == nil {
+		namespaces = []string{}
+	}
+	activation := map[string]interface{}{
+		"request": map[string]interface{}{
+			"name":      input.Name,
+			"namespace": input.Namespace,
+			"policy":    input.Policy,
+			"duration":  input.Duration,
+		},
+		"user": map[string]interface{}{
+			"username": input.Requester.Username,
+			"uid":      input.Requester.UID,
+			"groups":   groups,
+			"extra":    extra,
+		},
+		"namespaces": namespaces,
+		"now":        input.Now,
+	}
+
+	for i, program := range programs {
+		condition := policySpec.Conditions[i]
+		out, _, err := program.Eval(activation)
+		if err != nil {
+			return fmt.Errorf("condition '%s' failed to evaluate: %v", condition.Name, err)
+		}
+		satisfied, ok := out.Value().(bool)
+		if !ok {
+			return fmt.Errorf("condition '%s' did not evaluate to a bool", condition.Name)
+		}
+		if !satisfied {
+			if condition.Message != "" {
+				return fmt.Errorf("%s", condition.Message)
+			}
+			return fmt.Errorf("condition '%s' is not satisfied", condition.Name)
+		}
+	}
+	return nil
+}
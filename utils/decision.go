@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// DecisionInput is the request context sent to an external policy decision point.
+type DecisionInput struct {
+	Kind           string              `json:"kind"`                     // SudoRequest or ClusterSudoRequest
+	Name           string              `json:"name"`                     // Name of the request
+	Namespace      string              `json:"namespace,omitempty"`      // Namespace of the request, empty for cluster-scoped requests
+	Policy         string              `json:"policy"`                   // Name of the policy the request refers to
+	Duration       string              `json:"duration"`                 // Requested duration
+	Requester      v1.UserIdentity     `json:"requester"`                // Identity of the requester
+	RoleRef        rbacv1.RoleRef      `json:"roleRef"`                  // Role granted by the policy
+	AccessProfile  string              `json:"accessProfile,omitempty"`  // Access profile granted by the policy instead of a single role
+	Rules          []rbacv1.PolicyRule `json:"rules,omitempty"`          // Inline rules granted by the policy instead of a single role
+	RequestedRules []rbacv1.PolicyRule `json:"requestedRules,omitempty"` // Subset of the permissions of the policy requested, all of them if empty
+	ResourceNames  []string            `json:"resourceNames,omitempty"`  // Objects the grant is restricted to, <resource>/<name>
+	Namespaces     []string            `json:"namespaces"`               // Namespaces the access would be granted in
+}
+
+// Decision is the answer of an external policy decision point.
+type Decision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// DecisionClient queries an external, OPA compatible, policy decision point over HTTP.
+// The input is posted as {"input": ...} and the answer is read from the "result" field,
+// which is either a bool or an object with "allow" and "reason".
+type DecisionClient struct {
+	URL        string        // Endpoint of the decision point, e.g. http://opa:8181/v1/data/tarbac/decision
+	Timeout    time.Duration // Timeout of a single query
+	FailOpen   bool          // Allow requests when the decision point cannot be reached
+	CacheTTL   time.Duration // How long decisions are cached, 0 disables caching
+	HTTPClient *http.Client
+
+	cache ttlCache[Decision]
+}
+
+// NewDecisionClient creates a DecisionClient for the given endpoint.
+func NewDecisionClient(url string, timeout time.Duration, failOpen bool, cacheTTL time.Duration) *DecisionClient {
+	return &DecisionClient{
+		URL:        url,
+		Timeout:    timeout,
+		FailOpen:   failOpen,
+		CacheTTL:   cacheTTL,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Decide queries the decision point for the given input. When the decision point cannot be
+// reached or answers with an invalid response, the error is returned together with the
+// fail-open or fail-closed decision.
+func (c *DecisionClient) Decide(ctx context.Context, input DecisionInput) (Decision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return c.failureDecision(err)
+	}
+
+	key := cacheKey(body)
+	if decision, ok := c.cache.get(key); ok {
+		return decision, nil
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return c.failureDecision(err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return c.failureDecision(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return c.failureDecision(fmt.Errorf("decision point returned status %d", response.StatusCode))
+	}
+
+	var answer struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		return c.failureDecision(fmt.Errorf("failed to decode decision: %v", err))
+	}
+	decision, err := parseDecision(answer.Result)
+	if err != nil {
+		return c.failureDecision(err)
+	}
+
+	c.cache.set(key, decision, c.CacheTTL)
+	return decision, nil
+}
+
+// parseDecision reads a decision that is either a bool or an object with "allow" and "reason".
+func parseDecision(result json.RawMessage) (Decision, error) {
+	if len(result) == 0 {
+		return Decision{}, fmt.Errorf("decision point returned no result")
+	}
+	var decision Decision
+	if err := json.Unmarshal(result, &decision.Allow); err != nil {
+		if err := json.Unmarshal(result, &decision); err != nil {
+			return Decision{}, fmt.Errorf("failed to decode decision: %v", err)
+		}
+	}
+	if !decision.Allow && decision.Reason == "" {
+		decision.Reason = "no reason given"
+	}
+	return decision, nil
+}
+
+func (c *DecisionClient) failureDecision(err error) (Decision, error) {
+	if c.FailOpen {
+		return Decision{Allow: true, Reason: "decision point unavailable, failing open"}, err
+	}
+	return Decision{Allow: false, Reason: "decision point unavailable, failing closed"}, err
+}
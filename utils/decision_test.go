@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newDecisionServer starts a decision point answering every query with the given result, and counts the queries.
+func newDecisionServer(t *testing.T, result interface{}, queries *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(queries, 1)
+		var body struct {
+			Input DecisionInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testDecisionInput() DecisionInput {
+	return DecisionInput{
+		Kind:       "SudoRequest",
+		Name:       "example",
+		Namespace:  "default",
+		Policy:     "example-policy",
+		Duration:   "1h",
+		Namespaces: []string{"default"},
+	}
+}
+
+func TestDecideAllow(t *testing.T) {
+	var queries int32
+	server := newDecisionServer(t, true, &queries)
+	client := NewDecisionClient(server.URL, time.Second, false, 0)
+
+	decision, err := client.Decide(context.Background(), testDecisionInput())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allow {
+		t.Fatalf("expected the request to be allowed, got %+v", decision)
+	}
+}
+
+func TestDecideDenyWithReason(t *testing.T) {
+	var queries int32
+	server := newDecisionServer(t, map[string]interface{}{"allow": false, "reason": "on-call only"}, &queries)
+	client := NewDecisionClient(server.URL, time.Second, false, 0)
+
+	decision, err := client.Decide(context.Background(), testDecisionInput())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allow || decision.Reason != "on-call only" {
+		t.Fatalf("expected a deny with the reason of the decision point, got %+v", decision)
+	}
+}
+
+func TestDecideTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := NewDecisionClient(server.URL, 50*time.Millisecond, false, 0)
+
+	decision, err := client.Decide(context.Background(), testDecisionInput())
+	if err == nil {
+		t.Fatalf("expected a timeout error, got %+v", decision)
+	}
+	if decision.Allow {
+		t.Fatalf("expected the request not to be allowed on timeout, got %+v", decision)
+	}
+}
+
+func TestDecideFailOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	client := NewDecisionClient(server.URL, time.Second, true, 0)
+
+	decision, err := client.Decide(context.Background(), testDecisionInput())
+	if err == nil {
+		t.Fatalf("expected an error for the unavailable decision point")
+	}
+	if !decision.Allow {
+		t.Fatalf("expected the request to be allowed when failing open, got %+v", decision)
+	}
+}
+
+func TestDecideFailClosed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	t.Cleanup(server.Close)
+	client := NewDecisionClient(server.URL, time.Second, false, 0)
+
+	decision, err := client.Decide(context.Background(), testDecisionInput())
+	if err == nil {
+		t.Fatalf("expected an error for the invalid response")
+	}
+	if decision.Allow {
+		t.Fatalf("expected the request not to be allowed when failing closed, got %+v", decision)
+	}
+}
+
+func TestDecideCache(t *testing.T) {
+	var queries int32
+	server := newDecisionServer(t, true, &queries)
+	client := NewDecisionClient(server.URL, time.Second, false, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Decide(context.Background(), testDecisionInput()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Fatalf("expected the decision to be cached after the first query, got %d queries", n)
+	}
+
+	other := testDecisionInput()
+	other.Duration = "2h"
+	if _, err := client.Decide(context.Background(), other); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 2 {
+		t.Fatalf("expected a different input to query the decision point, got %d queries", n)
+	}
+}
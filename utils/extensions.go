@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+)
+
+// CheckExtension validates an extension of a grant that started at createdAt and expires at expiresAt.
+// The extension itself may not exceed the maxDuration of the policy, and the total lifetime of the grant
+// including the extension may not exceed its maxTotalDuration, when set.
+func CheckExtension(policySpec v1.SudoPolicySpec, extension string, createdAt time.Time, expiresAt time.Time) (time.Duration, error) {
+	duration, err := time.ParseDuration(extension)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid extension duration: %s", extension)
+	}
+	maxDuration, err := time.ParseDuration(policySpec.MaxDuration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid maxDuration in policy spec: %s", policySpec.MaxDuration)
+	}
+	if duration > maxDuration {
+		return 0, fmt.Errorf("extension %s exceeds max allowed duration %s", duration, maxDuration)
+	}
+	if policySpec.MaxTotalDuration != "" {
+		maxTotalDuration, err := time.ParseDuration(policySpec.MaxTotalDuration)
+		if err != nil {
+			return 0, fmt.Errorf("invalid maxTotalDuration in policy spec: %s", policySpec.MaxTotalDuration)
+		}
+		if total := expiresAt.Add(duration).Sub(createdAt); total > maxTotalDuration {
+			return 0, fmt.Errorf("extended lifetime %s exceeds max total duration %s", total, maxTotalDuration)
+		}
+	}
+	return duration, nil
+}
+
+// ProcessedExtensions returns how many of the extensions requested by annotation have been processed. Records of
+// duplicate requests merged into the request are not counted, as they do not correspond to a requested extension.
+func ProcessedExtensions(records []v1.ExtensionRecord) int {
+	var processed int
+	for _, record := range records {
+		if record.MergedFrom == "" {
+			processed++
+		}
+	}
+	return processed
+}
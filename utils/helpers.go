@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -17,6 +20,12 @@ func truncate(input string, maxLength int) string {
 	return input
 }
 
+// shortHash returns a short hash of the input, which keeps names that are truncated to a common prefix apart.
+func shortHash(input string) string {
+	sum := sha256.Sum256([]byte(input))
+	return hex.EncodeToString(sum[:])[:6]
+}
+
 func trimUID(uid string, length int) string {
 	trimmedUID := uid
 	if len(uid) > length {
@@ -26,23 +35,46 @@ func trimUID(uid string, length int) string {
 }
 
 // GenerateBindingName generates a unique name for the binding, limited to 63 characters.
+// The roles of an access profile share the request UID, so the kind of the role and a hash of the full
+// role reference keep a Role and a ClusterRole of the same name, and roles with a common prefix, apart.
 func GenerateBindingName(subject rbacv1.Subject, roleRef rbacv1.RoleRef, uid string) string {
 	// Trim components to fit within 63 characters.
-	kind := truncate(strings.ToLower(subject.Kind), 10) // Max 10 characters for Kind.
-	name := truncate(subject.Name, 20)                  // Max 20 characters for Subject Name.
-	role := truncate(roleRef.Name, 20)                  // Max 20 characters for Role Name.
-	trimmedUID := trimUID(uid, 12)                      // Always use the last 12 characters of UID.
-	return fmt.Sprintf("%s-%s-%s-%s", kind, name, role, trimmedUID)
+	kind := truncate(strings.ToLower(subject.Kind), 10)                               // Max 10 characters for Kind.
+	name := truncate(subject.Name, 20)                                                // Max 20 characters for Subject Name.
+	roleKind := roleKindAbbreviation(roleRef.Kind)                                    // Max 2 characters for the Kind of the Role.
+	role := truncate(roleRef.Name, 8)                                                 // Max 8 characters for Role Name.
+	roleHash := shortHash(roleRef.APIGroup + "/" + roleRef.Kind + "/" + roleRef.Name) // Always 6 characters for the hash of the Role.
+	trimmedUID := trimUID(uid, 12)                                                    // Always use the last 12 characters of UID.
+	return fmt.Sprintf("%s-%s-%s-%s-%s-%s", kind, name, roleKind, role, roleHash, trimmedUID)
+}
+
+// roleKindAbbreviation returns the abbreviation of the kind of a role used in binding names.
+func roleKindAbbreviation(kind string) string {
+	switch kind {
+	case "ClusterRole":
+		return "cr"
+	case "Role":
+		return "r"
+	}
+	return truncate(strings.ToLower(kind), 2)
 }
 
 // GenerateTempRBACName generates a unique name for Temporary RBAC resources, limited to 63 characters.
+// The policies of a bundle share the request UID, so a hash of the full policy name keeps policies
+// with a common prefix apart.
 func GenerateTempRBACName(subject rbacv1.Subject, sudoPolicy string, uid string) string {
 	// Trim components to fit within 63 characters.
 	kind := truncate(strings.ToLower(subject.Kind), 10) // Max 10 characters for Kind.
 	name := truncate(subject.Name, 20)                  // Max 20 characters for Subject Name.
-	policy := truncate(sudoPolicy, 20)                  // Max 20 characters for SudoPolicy Name.
+	policy := truncate(sudoPolicy, 10)                  // Max 10 characters for SudoPolicy Name.
+	policyHash := shortHash(sudoPolicy)                 // Always 6 characters for the hash of the SudoPolicy Name.
 	trimmedUID := trimUID(uid, 12)                      // Always use the last 12 characters of UID.
-	return fmt.Sprintf("%s-%s-%s-%s", kind, name, policy, trimmedUID)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", kind, name, policy, policyHash, trimmedUID)
+}
+
+// GenerateOccurrenceName generates a unique name for the resources created by one occurrence of a recurring request.
+func GenerateOccurrenceName(name string, uid string, scheduledTime time.Time) string {
+	return fmt.Sprintf("%s-%s-%d", truncate(name, 30), trimUID(uid, 8), scheduledTime.Unix())
 }
 
 func LogInfoUID(logger logr.Logger, message string, requestID string, additionalFields ...interface{}) {
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LockdownScope describes the access a lockdown is checked against.
+type LockdownScope struct {
+	Namespaces []string // Namespaces the access applies to, "*" for the whole cluster
+	Policy     string   // Name of the policy the access was granted by
+	PolicyKind string   // Kind of the policy, SudoPolicy or ClusterSudoPolicy, empty for grants that do not record it
+	Users      []string // Users granted the access
+}
+
+// FindLockdown returns the first lockdown that covers the scope, or nil when the access is not locked down.
+func FindLockdown(ctx context.Context, c client.Reader, scope LockdownScope) (*v1.Lockdown, error) {
+	var lockdowns v1.LockdownList
+	if err := c.List(ctx, &lockdowns); err != nil {
+		return nil, fmt.Errorf("failed to list lockdowns: %v", err)
+	}
+	for i := range lockdowns.Items {
+		lockdown := &lockdowns.Items[i]
+		if lockdown.DeletionTimestamp == nil && LockdownCovers(lockdown.Spec, scope) {
+			return lockdown, nil
+		}
+	}
+	return nil, nil
+}
+
+// LockdownCovers reports whether a lockdown applies to the scope. Every scope of the lockdown that is set
+// must match, and access to the whole cluster is covered by any namespace. Policies are matched by kind and
+// name, so that a lockdown of a ClusterSudoPolicy does not cover a SudoPolicy of the same name.
+func LockdownCovers(spec v1.LockdownSpec, scope LockdownScope) bool {
+	if len(spec.Namespaces) > 0 && !containsString(scope.Namespaces, "*") && !matchesAnyPattern(spec.Namespaces, scope.Namespaces) {
+		return false
+	}
+	if len(spec.Policies) > 0 && (!policyKindMatches(spec.PolicyKind, scope.PolicyKind) || !matchesAnyPattern(spec.Policies, []string{scope.Policy})) {
+		return false
+	}
+	if len(spec.Users) > 0 && !matchesAnyPattern(spec.Users, scope.Users) {
+		return false
+	}
+	return true
+}
+
+// policyKindMatches reports whether the policy kind of a lockdown covers the kind of a policy. Lockdowns created
+// before the kind was required, and grants that do not record the kind of their policy, match either kind.
+func policyKindMatches(lockdownKind string, policyKind string) bool {
+	return lockdownKind == "" || policyKind == "" || lockdownKind == policyKind
+}
+
+// LockdownRevocation returns the revocation recorded on a request that was revoked by a lockdown.
+func LockdownRevocation(lockdown *v1.Lockdown) *v1.Revocation {
+	return &v1.Revocation{
+		Lockdown:  lockdown.Name,
+		Reason:    lockdown.Spec.Reason,
+		Timestamp: metav1.Time{Time: time.Now()},
+	}
+}
+
+// SubjectUsers returns the names of the users among the subjects.
+func SubjectUsers(subjects []rbacv1.Subject) []string {
+	var users []string
+	for _, subject := range subjects {
+		if subject.Kind == rbacv1.UserKind {
+			users = append(users, subject.Name)
+		}
+	}
+	return users
+}
+
+func matchesAnyPattern(patterns []string, values []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if MatchesPattern(pattern, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Notification describes an event that needs the immediate attention of an operator.
+type Notification struct {
+	Severity      string `json:"severity"`                // e.g. critical
+	Reason        string `json:"reason"`                  // Reason of the matching Kubernetes event, e.g. BreakGlass
+	Kind          string `json:"kind"`                    // Kind of the request
+	Name          string `json:"name"`                    // Name of the request
+	Namespace     string `json:"namespace,omitempty"`     // Namespace of the request, empty for cluster-scoped requests
+	RequestID     string `json:"requestID"`               // RequestID of the request
+	Requester     string `json:"requester"`               // Username of the requester
+	Policy        string `json:"policy"`                  // Name of the policy the request refers to
+	Justification string `json:"justification,omitempty"` // Justification given by the requester
+	Message       string `json:"message"`                 // Human readable summary
+}
+
+// Notifier posts notifications as JSON to an HTTP endpoint, such as a chat or paging webhook.
+type Notifier struct {
+	URL        string // Endpoint notifications are posted to
+	HTTPClient *http.Client
+}
+
+// NewNotifier creates a Notifier for the given endpoint.
+func NewNotifier(url string, timeout time.Duration) *Notifier {
+	return &Notifier{
+		URL:        url,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts the notification. A nil Notifier discards it, so that notifications stay optional.
+func (n *Notifier) Notify(ctx context.Context, notification Notification) error {
+	if n == nil {
+		return nil
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ValidatePolicyRoles checks that a policy grants exactly one of a single role, an access profile or inline rules.
+func ValidatePolicyRoles(policySpec v1.SudoPolicySpec) error {
+	var set int
+	for _, isSet := range []bool{policySpec.RoleRef.Name != "", policySpec.AccessProfile != "", len(policySpec.Rules) > 0} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of roleRef, accessProfile or rules must be set")
+	}
+	return nil
+}
+
+// ValidateAccessProfile checks that the roles of an access profile are well formed and that no two of them
+// would be bound under the same name.
+func ValidateAccessProfile(profileSpec v1.AccessProfileSpec) error {
+	if len(profileSpec.Roles) == 0 {
+		return fmt.Errorf("an access profile must grant at least one role")
+	}
+	// Bindings are named after the kind and name of the role, so two entries of the same role collide when they
+	// are bound in the same namespace, which roles not scoped to a namespace are in every namespace.
+	scopes := map[string][]string{}
+	for _, role := range profileSpec.Roles {
+		if role.Kind != "Role" && role.Kind != "ClusterRole" {
+			return fmt.Errorf("unsupported kind '%s' of role '%s', expected Role or ClusterRole", role.Kind, role.Name)
+		}
+		if role.Name == "" {
+			return fmt.Errorf("role names must not be empty")
+		}
+		key := role.Kind + "/" + role.Name
+		for _, namespace := range scopes[key] {
+			if namespace == role.Namespace || namespace == "" || role.Namespace == "" {
+				return fmt.Errorf("%s is granted more than once in the same namespace", DescribeRoleRef(role.RoleRef))
+			}
+		}
+		scopes[key] = append(scopes[key], role.Namespace)
+	}
+	return nil
+}
+
+// GetAccessProfile fetches the access profile granted by a policy and checks that it is well formed.
+func GetAccessProfile(ctx context.Context, c client.Reader, name string) (*v1.AccessProfile, error) {
+	var profile v1.AccessProfile
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, &profile); err != nil {
+		return nil, fmt.Errorf("failed to get access profile '%s': %v", name, err)
+	}
+	if err := ValidateAccessProfile(profile.Spec); err != nil {
+		return nil, fmt.Errorf("invalid access profile '%s': %v", name, err)
+	}
+	return &profile, nil
+}
+
+// PolicyGrant is what the TemporaryRBACs created under a policy bind: the single role of the policy, the roles
+// of its access profile as they are at the time of the grant, or an ephemeral role with its inline rules.
+type PolicyGrant struct {
+	RoleRef *rbacv1.RoleRef
+	Roles   []v1.RoleRefWithNamespace
+	Rules   []rbacv1.PolicyRule
+}
+
+// ResolveGrant returns what the TemporaryRBACs created under a policy bind, fetching its access profile if it has one.
+func ResolveGrant(ctx context.Context, c client.Reader, policySpec v1.SudoPolicySpec) (PolicyGrant, error) {
+	if len(policySpec.Rules) > 0 {
+		return PolicyGrant{Rules: policySpec.Rules}, nil
+	}
+	if policySpec.AccessProfile == "" {
+		roleRef := policySpec.RoleRef
+		return PolicyGrant{RoleRef: &roleRef}, nil
+	}
+	profile, err := GetAccessProfile(ctx, c, policySpec.AccessProfile)
+	if err != nil {
+		return PolicyGrant{}, err
+	}
+	return PolicyGrant{Roles: profile.Spec.Roles}, nil
+}
+
+// InNamespace returns the grant of a TemporaryRBAC in a namespace, which binds only the roles of an access
+// profile that apply to the namespace and the inline rules a Role can hold, and reports whether it binds
+// anything at all.
+func (g PolicyGrant) InNamespace(namespace string) (PolicyGrant, bool) {
+	switch {
+	case len(g.Roles) > 0:
+		g.Roles = RolesInNamespace(g.Roles, namespace)
+		return g, len(g.Roles) > 0
+	case len(g.Rules) > 0:
+		g.Rules = NamespacedRules(g.Rules)
+		return g, len(g.Rules) > 0
+	}
+	return g, true
+}
+
+// RolesInNamespace returns the roles of an access profile that are bound in a namespace: those scoped to it
+// and those not scoped to any namespace.
+func RolesInNamespace(roles []v1.RoleRefWithNamespace, namespace string) []v1.RoleRefWithNamespace {
+	var inNamespace []v1.RoleRefWithNamespace
+	for _, role := range roles {
+		if role.Namespace == "" || role.Namespace == namespace {
+			inNamespace = append(inNamespace, role)
+		}
+	}
+	return inNamespace
+}
+
+// BindingRoles returns the existing roles a TemporaryRBAC or ClusterTemporaryRBAC binds: the roles of an access
+// profile when they are set, otherwise its single roleRef. Ephemeral roles of inline rules are not included.
+func BindingRoles(spec *v1.TemporaryRBACSpec) []v1.RoleRefWithNamespace {
+	if len(spec.Roles) > 0 {
+		return spec.Roles
+	}
+	if spec.RoleRef != nil {
+		return []v1.RoleRefWithNamespace{{RoleRef: *spec.RoleRef}}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RequesterIndex is the field index of SudoRequests and ClusterSudoRequests by the username of their requester
+const RequesterIndex = "spec.requester.username"
+
+// IndexRequester returns the requester username of a SudoRequest or ClusterSudoRequest for the RequesterIndex.
+func IndexRequester(obj client.Object) []string {
+	switch request := obj.(type) {
+	case *v1.SudoRequest:
+		if request.Spec.Requester.Username != "" {
+			return []string{request.Spec.Requester.Username}
+		}
+	case *v1.ClusterSudoRequest:
+		if request.Spec.Requester.Username != "" {
+			return []string{request.Spec.Requester.Username}
+		}
+	}
+	return nil
+}
+
+// Grant is the period during which a past request granted access.
+type Grant struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ValidateQuotas checks that the quotas and the cooldown of a policy are well formed.
+func ValidateQuotas(policySpec v1.SudoPolicySpec) error {
+	for _, quota := range policySpec.Quotas {
+		window, err := time.ParseDuration(quota.Window)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid quota window '%s'", quota.Window)
+		}
+		if quota.MaxRequests < 0 {
+			return fmt.Errorf("maxRequests of the quota per %s must not be negative: %d", quota.Window, quota.MaxRequests)
+		}
+		if quota.MaxCumulativeDuration != "" {
+			if _, err := time.ParseDuration(quota.MaxCumulativeDuration); err != nil {
+				return fmt.Errorf("invalid maxCumulativeDuration '%s' of the quota per %s", quota.MaxCumulativeDuration, quota.Window)
+			}
+		}
+		if quota.MaxRequests == 0 && quota.MaxCumulativeDuration == "" {
+			return fmt.Errorf("the quota per %s must set maxRequests or maxCumulativeDuration", quota.Window)
+		}
+	}
+	if policySpec.Cooldown != "" {
+		if _, err := time.ParseDuration(policySpec.Cooldown); err != nil {
+			return fmt.Errorf("invalid cooldown '%s'", policySpec.Cooldown)
+		}
+	}
+	return nil
+}
+
+// HasQuotas reports whether a policy limits the requests of a user over time.
+func HasQuotas(policySpec v1.SudoPolicySpec) bool {
+	return len(policySpec.Quotas) > 0 || policySpec.Cooldown != ""
+}
+
+// RequestGrant returns the period during which a request granted access, from the start of its access until
+// it expired or was revoked. Requests that never granted access are reported as such.
+func RequestGrant(creationTimestamp metav1.Time, spec *v1.SudoRequestSpec, status *v1.SudoRequestStatus) (Grant, bool) {
+	if status.State != "Approved" && status.State != "Expired" && status.State != "Revoked" {
+		return Grant{}, false
+	}
+	if len(status.ChildResource) == 0 {
+		return Grant{}, false
+	}
+
+	grant := Grant{Start: creationTimestamp.Time}
+	if status.CreatedAt != nil {
+		grant.Start = status.CreatedAt.Time
+	} else if spec.StartTime != nil && spec.StartTime.After(grant.Start) {
+		grant.Start = spec.StartTime.Time
+	}
+	if status.ExpiresAt != nil {
+		grant.End = status.ExpiresAt.Time
+	} else if duration, err := time.ParseDuration(spec.Duration); err == nil {
+		grant.End = grant.Start.Add(duration)
+	} else {
+		grant.End = grant.Start
+	}
+	if status.Revocation != nil && status.Revocation.Timestamp.Time.Before(grant.End) {
+		grant.End = status.Revocation.Timestamp.Time
+		if grant.End.Before(grant.Start) {
+			grant.End = grant.Start
+		}
+	}
+	return grant, true
+}
+
+// SudoRequestGrants returns the grants of the other SudoRequests of a user under a policy.
+func SudoRequestGrants(ctx context.Context, c client.Reader, namespace string, policy string, username string, exclude string) ([]Grant, error) {
+	var sudoRequests v1.SudoRequestList
+	if err := c.List(ctx, &sudoRequests, client.InNamespace(namespace), client.MatchingFields{RequesterIndex: username}); err != nil {
+		return nil, fmt.Errorf("failed to list SudoRequests of user '%s': %v", username, err)
+	}
+	var grants []Grant
+	for i := range sudoRequests.Items {
+		sudoRequest := &sudoRequests.Items[i]
+		if sudoRequest.Name == exclude || !HasPolicy(&sudoRequest.Spec, &sudoRequest.Status, policy) {
+			continue
+		}
+		if grant, ok := RequestGrant(sudoRequest.CreationTimestamp, &sudoRequest.Spec, &sudoRequest.Status); ok {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+// ClusterSudoRequestGrants returns the grants of the other ClusterSudoRequests of a user under a policy.
+func ClusterSudoRequestGrants(ctx context.Context, c client.Reader, policy string, username string, exclude string) ([]Grant, error) {
+	var clusterSudoRequests v1.ClusterSudoRequestList
+	if err := c.List(ctx, &clusterSudoRequests, client.MatchingFields{RequesterIndex: username}); err != nil {
+		return nil, fmt.Errorf("failed to list ClusterSudoRequests of user '%s': %v", username, err)
+	}
+	var grants []Grant
+	for i := range clusterSudoRequests.Items {
+		clusterSudoRequest := &clusterSudoRequests.Items[i]
+		if clusterSudoRequest.Name == exclude || !HasPolicy(&clusterSudoRequest.Spec, &clusterSudoRequest.Status, policy) {
+			continue
+		}
+		if grant, ok := RequestGrant(clusterSudoRequest.CreationTimestamp, &clusterSudoRequest.Spec, &clusterSudoRequest.Status); ok {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+// CheckQuotas checks a request for the given duration against the quotas and the cooldown of its policy,
+// given the previous grants of the same user. Grants count towards a quota when they started within its
+// window. It returns why the request exceeds a limit, or an empty string when it does not.
+func CheckQuotas(policySpec v1.SudoPolicySpec, grants []Grant, requested time.Duration, now time.Time) (string, error) {
+	sort.Slice(grants, func(i, j int) bool { return grants[i].Start.Before(grants[j].Start) })
+
+	for _, quota := range policySpec.Quotas {
+		window, err := time.ParseDuration(quota.Window)
+		if err != nil {
+			return "", fmt.Errorf("invalid quota window '%s': %v", quota.Window, err)
+		}
+		since := now.Add(-window)
+
+		var inWindow []Grant
+		var used time.Duration
+		for _, grant := range grants {
+			if grant.Start.After(since) {
+				inWindow = append(inWindow, grant)
+				used += grant.End.Sub(grant.Start)
+			}
+		}
+
+		if quota.MaxRequests > 0 && len(inWindow) >= quota.MaxRequests {
+			// The request fits again once enough of the grants in the window have left it
+			nextAt := inWindow[len(inWindow)-quota.MaxRequests].Start.Add(window)
+			return fmt.Sprintf("quota of %d requests per %s reached (%d granted), the next request is possible at %s",
+				quota.MaxRequests, quota.Window, len(inWindow), nextAt.Format(time.RFC3339)), nil
+		}
+
+		if quota.MaxCumulativeDuration != "" {
+			maxCumulative, err := time.ParseDuration(quota.MaxCumulativeDuration)
+			if err != nil {
+				return "", fmt.Errorf("invalid maxCumulativeDuration '%s': %v", quota.MaxCumulativeDuration, err)
+			}
+			if used+requested > maxCumulative {
+				remaining := maxCumulative - used
+				if remaining < 0 {
+					remaining = 0
+				}
+				return fmt.Sprintf("quota of %s of access per %s exceeded: %s already granted, %s requested, %s remaining",
+					quota.MaxCumulativeDuration, quota.Window, used, requested, remaining), nil
+			}
+		}
+	}
+
+	if policySpec.Cooldown != "" && len(grants) > 0 {
+		cooldown, err := time.ParseDuration(policySpec.Cooldown)
+		if err != nil {
+			return "", fmt.Errorf("invalid cooldown '%s': %v", policySpec.Cooldown, err)
+		}
+		var lastEnd time.Time
+		for _, grant := range grants {
+			if grant.End.After(lastEnd) {
+				lastEnd = grant.End
+			}
+		}
+		if nextAt := lastEnd.Add(cooldown); now.Before(nextAt) {
+			return fmt.Sprintf("cooldown of %s after the previous grant ending at %s lasts until %s",
+				policySpec.Cooldown, lastEnd.Format(time.RFC3339), nextAt.Format(time.RFC3339)), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxMissedOccurrences bounds the search for the latest missed occurrence of a schedule.
+const maxMissedOccurrences = 100000
+
+// ParseRecurrence parses a standard five field cron expression evaluated in the given time zone, UTC when empty.
+func ParseRecurrence(expression string, timeZone string) (cron.Schedule, *time.Location, error) {
+	location := time.UTC
+	if timeZone != "" {
+		loaded, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid time zone '%s': %v", timeZone, err)
+		}
+		location = loaded
+	}
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid schedule '%s': %v", expression, err)
+	}
+	return schedule, location, nil
+}
+
+// LatestOccurrence returns the latest time the schedule was due after since and not after now,
+// or the zero time when it was not due in between.
+func LatestOccurrence(schedule cron.Schedule, location *time.Location, since time.Time, now time.Time) time.Time {
+	var latest time.Time
+	for next, i := schedule.Next(since.In(location)), 0; !next.IsZero() && !next.After(now) && i < maxMissedOccurrences; next, i = schedule.Next(next), i+1 {
+		latest = next
+	}
+	return latest
+}
+
+// IsFinishedGrantState reports whether a TemporaryRBAC or ClusterTemporaryRBAC in the given state no longer grants
+// access and never will again, because it expired, was revoked or failed.
+func IsFinishedGrantState(state string) bool {
+	return state == "Expired" || state == "Revoked" || state == "Error"
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// splitResourceName splits an object named as "<resource>/<name>", e.g. secrets/db-credentials or pods/log/web-0,
+// into its resource and its name.
+func splitResourceName(resourceName string) (string, string, error) {
+	i := strings.LastIndex(resourceName, "/")
+	if i <= 0 || i == len(resourceName)-1 {
+		return "", "", fmt.Errorf("'%s' must be of the form <resource>/<name>", resourceName)
+	}
+	return resourceName[:i], resourceName[i+1:], nil
+}
+
+// ValidateResourceNamePatterns checks that the resource names a policy allows to be requested are of the form
+// <resource>/<name>, where both parts may contain wildcards.
+func ValidateResourceNamePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, _, err := splitResourceName(pattern); err != nil {
+			return fmt.Errorf("invalid allowedResourceNames: %v", err)
+		}
+	}
+	return nil
+}
+
+// CheckResourceNames checks that the objects a request restricts its grant to are allowed by the policy and that
+// the rules the request is granted, those requested or else those of the policy, grant access to them in every
+// namespace of the request, or cluster-wide for "*". It returns why they are not, or an empty string when they are.
+func CheckResourceNames(ctx context.Context, c client.Reader, policySpec v1.SudoPolicySpec, requested []rbacv1.PolicyRule, resourceNames []string, namespaces []string) (string, error) {
+	for _, resourceName := range resourceNames {
+		_, name, err := splitResourceName(resourceName)
+		if err != nil {
+			return fmt.Sprintf("invalid resource name: %v", err), nil
+		}
+		if strings.ContainsAny(name, "*?") {
+			return fmt.Sprintf("resource name '%s' must not contain wildcards", resourceName), nil
+		}
+		if len(policySpec.AllowedResourceNames) > 0 && !matchesAnyPattern(policySpec.AllowedResourceNames, []string{resourceName}) {
+			return fmt.Sprintf("resource name '%s' is not allowed by the policy", resourceName), nil
+		}
+	}
+	policyGrant, err := ResolveGrant(ctx, c, policySpec)
+	if err != nil {
+		return "", err
+	}
+	clusterWide := len(namespaces) == 1 && namespaces[0] == "*"
+	for _, namespace := range namespaces {
+		if _, ok := policyGrant.InNamespace(namespace); !ok && !clusterWide {
+			continue
+		}
+		granted, err := GrantRules(ctx, c, policyGrant.Narrow(requested), namespace)
+		if err != nil {
+			return "", err
+		}
+		restricted := RestrictRules(granted, resourceNames)
+		for _, resourceName := range resourceNames {
+			resource, name, _ := splitResourceName(resourceName)
+			if !grantsResourceName(restricted, resource, name) {
+				return fmt.Sprintf("'%s' is not granted by the policy %s", resourceName, describeScope(namespace)), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// RestrictGrant returns the grant of a request restricting its grant to some objects in a namespace, or
+// cluster-wide for "*": an ephemeral role holding the rules of the grant restricted to these objects.
+func RestrictGrant(ctx context.Context, c client.Reader, grant PolicyGrant, resourceNames []string, namespace string) (PolicyGrant, error) {
+	if len(resourceNames) == 0 {
+		return grant, nil
+	}
+	rules, err := GrantRules(ctx, c, grant, namespace)
+	if err != nil {
+		return PolicyGrant{}, err
+	}
+	return PolicyGrant{Rules: RestrictRules(rules, resourceNames)}, nil
+}
+
+// RestrictRules restricts rules to the named objects, keeping for every resource named the verbs and API groups
+// the rules grant on it. Rules already restricted to resource names only keep the names they grant.
+func RestrictRules(rules []rbacv1.PolicyRule, resourceNames []string) []rbacv1.PolicyRule {
+	var resources []string
+	names := map[string][]string{}
+	for _, resourceName := range resourceNames {
+		resource, name, err := splitResourceName(resourceName)
+		if err != nil {
+			continue
+		}
+		if _, ok := names[resource]; !ok {
+			resources = append(resources, resource)
+		}
+		names[resource] = append(names[resource], name)
+	}
+
+	var restricted []rbacv1.PolicyRule
+	for _, resource := range resources {
+		for _, rule := range rules {
+			if len(rule.NonResourceURLs) > 0 || !resourceCovered(rule.Resources, resource) {
+				continue
+			}
+			var granted []string
+			for _, name := range names[resource] {
+				if len(rule.ResourceNames) == 0 || containsString(rule.ResourceNames, name) {
+					granted = append(granted, name)
+				}
+			}
+			if len(granted) == 0 {
+				continue
+			}
+			restricted = append(restricted, rbacv1.PolicyRule{
+				Verbs:         rule.Verbs,
+				APIGroups:     rule.APIGroups,
+				Resources:     []string{resource},
+				ResourceNames: granted,
+			})
+		}
+	}
+	return restricted
+}
+
+func grantsResourceName(rules []rbacv1.PolicyRule, resource string, name string) bool {
+	for _, rule := range rules {
+		if containsString(rule.Resources, resource) && containsString(rule.ResourceNames, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestRestrictRules(t *testing.T) {
+	tests := []struct {
+		name          string
+		rules         []rbacv1.PolicyRule
+		resourceNames []string
+		restricted    []string
+	}{
+		{
+			name:          "resource",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"get", "update"}, APIGroups: []string{""}, Resources: []string{"secrets", "configmaps"}}},
+			resourceNames: []string{"secrets/db"},
+			restricted:    []string{"'get,update' on secrets 'db'"},
+		},
+		{
+			name:          "resource wildcard",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+			resourceNames: []string{"pods/log/web-0"},
+			restricted:    []string{"'get' on pods/log.* 'web-0'"},
+		},
+		{
+			name:          "subresource of every resource",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*/log"}}},
+			resourceNames: []string{"pods/log/web-0"},
+			restricted:    []string{"'get' on pods/log 'web-0'"},
+		},
+		{
+			name:          "no wildcard over the subresources of a resource",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/*"}}},
+			resourceNames: []string{"pods/exec/web-0"},
+		},
+		{
+			name:          "resource does not grant its subresources",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			resourceNames: []string{"pods/exec/web-0"},
+		},
+		{
+			name:          "names outside the resource names of the rule",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}}},
+			resourceNames: []string{"secrets/db", "secrets/tls"},
+			restricted:    []string{"'get' on secrets 'db'"},
+		},
+		{
+			name:          "non-resource URLs",
+			rules:         []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"*"}}},
+			resourceNames: []string{"secrets/db"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restricted := RestrictRules(test.rules, test.resourceNames)
+
+			// Every emitted rule must be granted by the source rules under the matching of the RBAC authorizer
+			if uncovered := UncoveredRules(test.rules, restricted); len(uncovered) > 0 {
+				t.Fatalf("restricted rules grant %s, which the source rules do not", DescribeRule(uncovered[0]))
+			}
+
+			var described []string
+			for _, rule := range restricted {
+				described = append(described, DescribeRule(rule))
+			}
+			if len(described) != len(test.restricted) {
+				t.Fatalf("expected %v, got %v", test.restricted, described)
+			}
+			for i := range described {
+				if described[i] != test.restricted[i] {
+					t.Fatalf("expected %v, got %v", test.restricted, described)
+				}
+			}
+		})
+	}
+}
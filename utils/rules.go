@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ValidateRules checks that the inline rules of a policy are well formed. Non-resource URLs can only be
+// granted by ClusterRoles, so only policies granting cluster-wide access may use them.
+func ValidateRules(rules []rbacv1.PolicyRule, allowNonResourceURLs bool) error {
+	for i, rule := range rules {
+		if len(rule.Verbs) == 0 {
+			return fmt.Errorf("rule %d must set verbs", i)
+		}
+		if len(rule.NonResourceURLs) > 0 {
+			if !allowNonResourceURLs {
+				return fmt.Errorf("rule %d sets nonResourceURLs, which only cluster-wide grants allow", i)
+			}
+			if len(rule.APIGroups) > 0 || len(rule.Resources) > 0 || len(rule.ResourceNames) > 0 {
+				return fmt.Errorf("rule %d must not set both nonResourceURLs and resources", i)
+			}
+			continue
+		}
+		if len(rule.APIGroups) == 0 || len(rule.Resources) == 0 {
+			return fmt.Errorf("rule %d must set apiGroups and resources, or nonResourceURLs", i)
+		}
+	}
+	return nil
+}
+
+// NamespacedRules returns the rules a Role can hold, leaving out those granting non-resource URLs.
+func NamespacedRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	var namespaced []rbacv1.PolicyRule
+	for _, rule := range rules {
+		if len(rule.NonResourceURLs) == 0 {
+			namespaced = append(namespaced, rule)
+		}
+	}
+	return namespaced
+}
+
+// EphemeralRoleName returns the name of the Role or ClusterRole created for the inline rules of a grant.
+func EphemeralRoleName(owner string) string {
+	return fmt.Sprintf("tarbac-%s", owner)
+}
+
+// Narrow returns the grant of a request asking for a subset of the permissions of its policy: an ephemeral role
+// holding only the rules requested. A request that does not ask for a subset is granted what the policy grants.
+func (g PolicyGrant) Narrow(requested []rbacv1.PolicyRule) PolicyGrant {
+	if len(requested) == 0 {
+		return g
+	}
+	return PolicyGrant{Rules: requested}
+}
+
+// CheckRequestedRules checks that the rules requested are covered by what the policy grants in every namespace of
+// the request, or cluster-wide when the namespaces are "*", and returns why they are not, or an empty string when
+// they are. Namespaces the policy grants nothing in are skipped, as no access is granted there.
+func CheckRequestedRules(ctx context.Context, c client.Reader, policySpec v1.SudoPolicySpec, requested []rbacv1.PolicyRule, namespaces []string) (string, error) {
+	clusterWide := len(namespaces) == 1 && namespaces[0] == "*"
+	if err := ValidateRules(requested, clusterWide); err != nil {
+		return fmt.Sprintf("invalid rules requested: %v", err), nil
+	}
+	grant, err := ResolveGrant(ctx, c, policySpec)
+	if err != nil {
+		return "", err
+	}
+	for _, namespace := range namespaces {
+		if _, ok := grant.InNamespace(namespace); !ok && !clusterWide {
+			continue
+		}
+		granted, err := GrantRules(ctx, c, grant, namespace)
+		if err != nil {
+			return "", err
+		}
+		if uncovered := UncoveredRules(granted, requested); len(uncovered) > 0 {
+			return fmt.Sprintf("%s is not granted by the policy %s", DescribeRule(uncovered[0]), describeScope(namespace)), nil
+		}
+	}
+	return "", nil
+}
+
+// GrantRules returns the rules a grant holds in a namespace, or in every namespace and at the cluster scope
+// for "*", following the aggregation rules of the ClusterRoles it binds.
+func GrantRules(ctx context.Context, c client.Reader, grant PolicyGrant, namespace string) ([]rbacv1.PolicyRule, error) {
+	if namespace == "*" {
+		return clusterWideRules(ctx, c, grant)
+	}
+	grant, ok := grant.InNamespace(namespace)
+	if !ok {
+		return nil, nil
+	}
+	return namespaceRules(ctx, c, grant, namespace)
+}
+
+func describeScope(namespace string) string {
+	if namespace == "*" {
+		return "cluster-wide"
+	}
+	return fmt.Sprintf("in namespace '%s'", namespace)
+}
+
+// namespaceRules returns the rules a grant holds in a namespace.
+func namespaceRules(ctx context.Context, c client.Reader, grant PolicyGrant, namespace string) ([]rbacv1.PolicyRule, error) {
+	rules := append([]rbacv1.PolicyRule{}, grant.Rules...)
+	for _, role := range BindingRoles(&v1.TemporaryRBACSpec{RoleRef: grant.RoleRef, Roles: grant.Roles}) {
+		roleRules, err := roleRules(ctx, c, role.RoleRef, namespace)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, roleRules...)
+	}
+	return rules, nil
+}
+
+// clusterWideRules returns the rules a grant holds in every namespace and at the cluster scope. Roles of an access
+// profile scoped to a namespace are only bound there, so they are left out.
+func clusterWideRules(ctx context.Context, c client.Reader, grant PolicyGrant) ([]rbacv1.PolicyRule, error) {
+	rules := append([]rbacv1.PolicyRule{}, grant.Rules...)
+	for _, role := range BindingRoles(&v1.TemporaryRBACSpec{RoleRef: grant.RoleRef, Roles: grant.Roles}) {
+		if role.Kind != "ClusterRole" || role.Namespace != "" {
+			continue
+		}
+		roleRules, err := clusterRoleRules(ctx, c, role.Name)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, roleRules...)
+	}
+	return rules, nil
+}
+
+// roleRules returns the rules of the Role or ClusterRole a grant binds in a namespace.
+func roleRules(ctx context.Context, c client.Reader, roleRef rbacv1.RoleRef, namespace string) ([]rbacv1.PolicyRule, error) {
+	if roleRef.Kind == "ClusterRole" {
+		return clusterRoleRules(ctx, c, roleRef.Name)
+	}
+	var role rbacv1.Role
+	if err := c.Get(ctx, client.ObjectKey{Name: roleRef.Name, Namespace: namespace}, &role); err != nil {
+		return nil, fmt.Errorf("failed to get Role '%s' in namespace '%s': %v", roleRef.Name, namespace, err)
+	}
+	return role.Rules, nil
+}
+
+// clusterRoleRules returns the rules of a ClusterRole together with those of the ClusterRoles it aggregates,
+// which the aggregation controller may not have copied into it yet.
+func clusterRoleRules(ctx context.Context, c client.Reader, name string) ([]rbacv1.PolicyRule, error) {
+	var clusterRole rbacv1.ClusterRole
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, &clusterRole); err != nil {
+		return nil, fmt.Errorf("failed to get ClusterRole '%s': %v", name, err)
+	}
+	rules := clusterRole.Rules
+	if clusterRole.AggregationRule == nil || len(clusterRole.AggregationRule.ClusterRoleSelectors) == 0 {
+		return rules, nil
+	}
+	var clusterRoles rbacv1.ClusterRoleList
+	if err := c.List(ctx, &clusterRoles); err != nil {
+		return nil, fmt.Errorf("failed to list the ClusterRoles aggregated by '%s': %v", name, err)
+	}
+	for _, clusterRoleSelector := range clusterRole.AggregationRule.ClusterRoleSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&clusterRoleSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aggregation rule of ClusterRole '%s': %v", name, err)
+		}
+		for _, aggregated := range clusterRoles.Items {
+			if aggregated.Name != name && selector.Matches(labels.Set(aggregated.Labels)) {
+				rules = append(rules, aggregated.Rules...)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// UncoveredRules breaks the requested rules down into a single verb on a single resource, resource name or
+// non-resource URL each, and returns those that none of the granted rules allows.
+func UncoveredRules(granted []rbacv1.PolicyRule, requested []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	var uncovered []rbacv1.PolicyRule
+	for _, rule := range requested {
+		for _, atom := range breakdownRule(rule) {
+			covered := false
+			for _, grantedRule := range granted {
+				if ruleCovers(grantedRule, atom) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				uncovered = append(uncovered, atom)
+			}
+		}
+	}
+	return uncovered
+}
+
+// breakdownRule splits a rule into rules with a single verb, API group, resource and resource name, or a single
+// verb and non-resource URL.
+func breakdownRule(rule rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	var atoms []rbacv1.PolicyRule
+	for _, verb := range rule.Verbs {
+		for _, url := range rule.NonResourceURLs {
+			atoms = append(atoms, rbacv1.PolicyRule{Verbs: []string{verb}, NonResourceURLs: []string{url}})
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				if len(rule.ResourceNames) == 0 {
+					atoms = append(atoms, rbacv1.PolicyRule{Verbs: []string{verb}, APIGroups: []string{group}, Resources: []string{resource}})
+					continue
+				}
+				for _, resourceName := range rule.ResourceNames {
+					atoms = append(atoms, rbacv1.PolicyRule{Verbs: []string{verb}, APIGroups: []string{group}, Resources: []string{resource}, ResourceNames: []string{resourceName}})
+				}
+			}
+		}
+	}
+	return atoms
+}
+
+// ruleCovers reports whether a granted rule allows a rule broken down by breakdownRule, following the matching of
+// the Kubernetes RBAC authorizer: wildcards, "*/<subresource>" and non-resource URL prefixes ending in "*".
+// A rule restricted to resource names does not cover requests for any name.
+func ruleCovers(granted rbacv1.PolicyRule, atom rbacv1.PolicyRule) bool {
+	if !containsOrWildcard(granted.Verbs, atom.Verbs[0]) {
+		return false
+	}
+	if len(atom.NonResourceURLs) > 0 {
+		for _, url := range granted.NonResourceURLs {
+			if url == rbacv1.NonResourceAll || url == atom.NonResourceURLs[0] ||
+				(strings.HasSuffix(url, "*") && strings.HasPrefix(atom.NonResourceURLs[0], strings.TrimSuffix(url, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+	if !containsOrWildcard(granted.APIGroups, atom.APIGroups[0]) || !resourceCovered(granted.Resources, atom.Resources[0]) {
+		return false
+	}
+	if len(granted.ResourceNames) == 0 {
+		return true
+	}
+	return len(atom.ResourceNames) > 0 && containsString(granted.ResourceNames, atom.ResourceNames[0])
+}
+
+func resourceCovered(resources []string, resource string) bool {
+	for _, granted := range resources {
+		if granted == rbacv1.ResourceAll || granted == resource {
+			return true
+		}
+		// "*/log" covers the log subresource of every resource. As in the RBAC authorizer there is no "pods/*"
+		// wildcard, such a rule grants nothing on the subresources of pods.
+		_, subresource, isSubresource := strings.Cut(resource, "/")
+		if isSubresource && granted == "*/"+subresource {
+			return true
+		}
+	}
+	return false
+}
+
+func containsOrWildcard(values []string, value string) bool {
+	return containsString(values, "*") || containsString(values, value)
+}
+
+// DescribeRule describes a rule broken down by breakdownRule for events and rejection messages.
+func DescribeRule(rule rbacv1.PolicyRule) string {
+	if len(rule.NonResourceURLs) > 0 {
+		return fmt.Sprintf("'%s' on non-resource URL '%s'", strings.Join(rule.Verbs, ","), strings.Join(rule.NonResourceURLs, ","))
+	}
+	resource := strings.Join(rule.Resources, ",")
+	if group := strings.Join(rule.APIGroups, ","); group != "" {
+		resource = fmt.Sprintf("%s.%s", resource, group)
+	}
+	if len(rule.ResourceNames) > 0 {
+		return fmt.Sprintf("'%s' on %s '%s'", strings.Join(rule.Verbs, ","), resource, strings.Join(rule.ResourceNames, ","))
+	}
+	return fmt.Sprintf("'%s' on %s", strings.Join(rule.Verbs, ","), resource)
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestRuleCovers(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted rbacv1.PolicyRule
+		atom    rbacv1.PolicyRule
+		covers  bool
+	}{
+		{
+			name:    "exact resource",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			covers:  true,
+		},
+		{
+			name:    "other verb",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"delete"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			covers:  false,
+		},
+		{
+			name:    "other API group",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{"extensions"}, Resources: []string{"deployments"}},
+			covers:  false,
+		},
+		{
+			name:    "wildcards",
+			granted: rbacv1.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}},
+			covers:  true,
+		},
+		{
+			name:    "resource does not cover its subresources",
+			granted: rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}},
+			covers:  false,
+		},
+		{
+			name:    "exact subresource",
+			granted: rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}},
+			covers:  true,
+		},
+		{
+			name:    "subresource of every resource",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*/log"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods/log"}},
+			covers:  true,
+		},
+		{
+			name:    "subresource wildcard of every resource does not cover other subresources",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*/log"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}},
+			covers:  false,
+		},
+		{
+			name:    "no wildcard over the subresources of a resource",
+			granted: rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/*"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}},
+			covers:  false,
+		},
+		{
+			name:    "resource name granted",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}},
+			covers:  true,
+		},
+		{
+			name:    "other resource name",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"tls"}},
+			covers:  false,
+		},
+		{
+			name:    "resource names do not cover every name",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+			covers:  false,
+		},
+		{
+			name:    "every name covers a resource name",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}},
+			covers:  true,
+		},
+		{
+			name:    "exact non-resource URL",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}},
+			covers:  true,
+		},
+		{
+			name:    "non-resource URL prefix",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics/*"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics/cadvisor"}},
+			covers:  true,
+		},
+		{
+			name:    "non-resource URL outside the prefix",
+			granted: rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics/*"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}},
+			covers:  false,
+		},
+		{
+			name:    "resource rule does not cover non-resource URLs",
+			granted: rbacv1.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+			atom:    rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}},
+			covers:  false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if covers := ruleCovers(test.granted, test.atom); covers != test.covers {
+				t.Fatalf("ruleCovers(%+v, %+v) = %v, expected %v", test.granted, test.atom, covers, test.covers)
+			}
+		})
+	}
+}
+
+func TestUncoveredRules(t *testing.T) {
+	granted := []rbacv1.PolicyRule{
+		{Verbs: []string{"get", "list", "watch"}, APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}},
+		{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/*"}},
+		{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db"}},
+	}
+	tests := []struct {
+		name      string
+		requested []rbacv1.PolicyRule
+		uncovered []string
+	}{
+		{
+			name:      "subset",
+			requested: []rbacv1.PolicyRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}}},
+		},
+		{
+			name:      "subresource only matched by a resource wildcard",
+			requested: []rbacv1.PolicyRule{{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods/exec"}}},
+			uncovered: []string{"'create' on pods/exec"},
+		},
+		{
+			name:      "resource names",
+			requested: []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"db", "tls"}}},
+			uncovered: []string{"'get' on secrets 'tls'"},
+		},
+		{
+			name:      "partially covered verbs",
+			requested: []rbacv1.PolicyRule{{Verbs: []string{"get", "delete"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			uncovered: []string{"'delete' on pods"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var uncovered []string
+			for _, rule := range UncoveredRules(granted, test.requested) {
+				uncovered = append(uncovered, DescribeRule(rule))
+			}
+			if len(uncovered) != len(test.uncovered) {
+				t.Fatalf("expected %v to be uncovered, got %v", test.uncovered, uncovered)
+			}
+			for i := range uncovered {
+				if uncovered[i] != test.uncovered[i] {
+					t.Fatalf("expected %v to be uncovered, got %v", test.uncovered, uncovered)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScheduleDecision is the result of evaluating a policy schedule at a point in time.
+type ScheduleDecision struct {
+	Allowed  bool      // Whether requests are allowed at that time
+	Reason   string    // Why requests are not allowed
+	ClosesAt time.Time // When the current window closes or the next blackout starts, zero if never
+}
+
+// parseClock parses a time of day in the HH:MM format into minutes since midnight, allowing "24:00".
+func parseClock(value string) (int, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil || len(value) != 5 {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+// parseWeekday parses a day name such as "Mon" or "Monday".
+func parseWeekday(value string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := day.String()
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			return day, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid day '%s'", value)
+}
+
+// ValidateSchedule checks that the time zone, windows and blackouts of a schedule are well formed.
+func ValidateSchedule(schedule *v1.AccessSchedule) error {
+	if schedule == nil {
+		return nil
+	}
+	if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone '%s': %v", schedule.TimeZone, err)
+	}
+	for _, window := range schedule.Windows {
+		for _, day := range window.Days {
+			if _, err := parseWeekday(day); err != nil {
+				return err
+			}
+		}
+		if _, err := parseClock(window.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(window.End); err != nil {
+			return err
+		}
+	}
+	for _, blackout := range schedule.Blackouts {
+		if !blackout.End.After(blackout.Start.Time) {
+			return fmt.Errorf("blackout '%s' must end after it starts", blackout.Name)
+		}
+	}
+	return nil
+}
+
+// EvaluateSchedule reports whether a schedule allows requests at the given time. Requests are allowed
+// inside any of the windows, or at any time when there are none, unless a blackout is in progress.
+func EvaluateSchedule(schedule *v1.AccessSchedule, at time.Time) (ScheduleDecision, error) {
+	if schedule == nil {
+		return ScheduleDecision{Allowed: true}, nil
+	}
+	if err := ValidateSchedule(schedule); err != nil {
+		return ScheduleDecision{}, err
+	}
+	location, _ := time.LoadLocation(schedule.TimeZone)
+	local := at.In(location)
+
+	decision := ScheduleDecision{Allowed: len(schedule.Windows) == 0}
+	for _, window := range schedule.Windows {
+		start, _ := parseClock(window.Start)
+		end, _ := parseClock(window.End)
+		// A window may span midnight, so the instances opened today and yesterday are both considered
+		for offset := -1; offset <= 0; offset++ {
+			day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, location)
+			if !windowIncludesDay(window, day.Weekday()) {
+				continue
+			}
+			opensAt := day.Add(time.Duration(start) * time.Minute)
+			closesAt := day.Add(time.Duration(end) * time.Minute)
+			if end <= start {
+				closesAt = closesAt.Add(24 * time.Hour)
+			}
+			if !local.Before(opensAt) && local.Before(closesAt) {
+				decision.Allowed = true
+				if closesAt.After(decision.ClosesAt) {
+					decision.ClosesAt = closesAt
+				}
+			}
+		}
+	}
+	if !decision.Allowed {
+		decision.Reason = fmt.Sprintf("requests are only allowed within the time windows of the policy (%s)", location)
+		return decision, nil
+	}
+
+	for _, blackout := range schedule.Blackouts {
+		if !at.Before(blackout.Start.Time) && at.Before(blackout.End.Time) {
+			return ScheduleDecision{
+				Allowed: false,
+				Reason:  fmt.Sprintf("blackout '%s' is in progress until %s", blackout.Name, blackout.End.Time.In(location).Format(time.RFC3339)),
+			}, nil
+		}
+		if blackout.Start.After(at) && (decision.ClosesAt.IsZero() || blackout.Start.Time.Before(decision.ClosesAt)) {
+			decision.ClosesAt = blackout.Start.Time
+		}
+	}
+	return decision, nil
+}
+
+func windowIncludesDay(window v1.TimeWindow, weekday time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, value := range window.Days {
+		if day, err := parseWeekday(value); err == nil && day == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantDuration returns the duration to grant for a request starting at the given time. When the policy
+// enforces its schedule, the grant is cut short at the close of the current window or the start of the
+// next blackout.
+func GrantDuration(policySpec v1.SudoPolicySpec, requested string, at time.Time) string {
+	if policySpec.Schedule == nil || !policySpec.Schedule.EnforceWindowEnd {
+		return requested
+	}
+	duration, err := time.ParseDuration(requested)
+	if err != nil {
+		return requested
+	}
+	decision, err := EvaluateSchedule(policySpec.Schedule, at)
+	if err != nil || decision.ClosesAt.IsZero() {
+		return requested
+	}
+	if remaining := decision.ClosesAt.Sub(at).Round(time.Second); remaining > 0 && remaining < duration {
+		return remaining.String()
+	}
+	return requested
+}
+
+// ActivationTime returns when the access of a request starts: its start time when that lies in the
+// future, or the given time otherwise.
+func ActivationTime(startTime *metav1.Time, now time.Time) time.Time {
+	if startTime != nil && startTime.Time.After(now) {
+		return startTime.Time
+	}
+	return now
+}
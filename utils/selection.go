@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// RequestPolicy returns the name of the policy a request is enforced under: the policy selected by the
+// controller once it is recorded in the status, otherwise the policy named in the spec.
+func RequestPolicy(spec *v1.SudoRequestSpec, status *v1.SudoRequestStatus) string {
+	if status.Policy != "" {
+		return status.Policy
+	}
+	return spec.Policy
+}
+
+// PolicyCandidate is a policy considered for a request that asks for a role instead of naming a policy.
+type PolicyCandidate struct {
+	Name       string
+	Spec       v1.SudoPolicySpec
+	Namespaces []string // Namespaces the policy grants access to, "*" for the whole cluster, unset for namespaced policies
+}
+
+// PolicyRequest is what a request asks for when its policy is selected automatically.
+type PolicyRequest struct {
+	RoleRef    rbacv1.RoleRef
+	Namespaces []string // Namespaces requested, any namespace of the policy if unset
+	Duration   time.Duration
+	Requester  v1.UserIdentity
+	BreakGlass bool
+}
+
+// CoversNamespaces reports whether every requested namespace is granted by a policy, which grants all of
+// them when its namespaces contain "*".
+func CoversNamespaces(allowed []string, requested []string) bool {
+	for _, namespace := range allowed {
+		if namespace == "*" {
+			return true
+		}
+	}
+	for _, namespace := range requested {
+		found := false
+		for _, candidate := range allowed {
+			if candidate == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// permits reports whether a policy would grant a request: it must grant the requested role and namespaces,
+// allow the requester and the requested duration, and allow break-glass requests when one is made. The
+// conditions, schedule, quotas and concurrency limits are left to the evaluation of the selected policy.
+func (c PolicyCandidate) permits(request PolicyRequest) bool {
+	if c.Spec.RoleRef != request.RoleRef {
+		return false
+	}
+	if !IsAllowedRequester(c.Spec, request.Requester) || CheckRequiredAttributes(c.Spec, request.Requester) != nil {
+		return false
+	}
+	maxDuration, err := time.ParseDuration(c.Spec.MaxDuration)
+	if err != nil || request.Duration > maxDuration {
+		return false
+	}
+	if request.BreakGlass && c.Spec.BreakGlass == nil {
+		return false
+	}
+	return CoversNamespaces(c.Namespaces, request.Namespaces)
+}
+
+// approvalStrictness ranks the approval a policy requires, automatic approval being the least strict.
+func approvalStrictness(policySpec v1.SudoPolicySpec) int {
+	if policySpec.ApprovalMode != "Manual" {
+		return 0
+	}
+	return RequiredApprovals(policySpec)
+}
+
+// SelectPolicy returns the policy to enforce on a request that asks for a role instead of naming a policy,
+// or nil when no candidate permits it. When several candidates do, the one with the shortest maxDuration
+// wins, then the one requiring the strictest approval, then the first by name.
+func SelectPolicy(candidates []PolicyCandidate, request PolicyRequest) *PolicyCandidate {
+	var permitted []PolicyCandidate
+	for _, candidate := range candidates {
+		if candidate.permits(request) {
+			permitted = append(permitted, candidate)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil
+	}
+	sort.Slice(permitted, func(i, j int) bool {
+		// Durations parsed successfully when the candidates were filtered
+		maxI, _ := time.ParseDuration(permitted[i].Spec.MaxDuration)
+		maxJ, _ := time.ParseDuration(permitted[j].Spec.MaxDuration)
+		if maxI != maxJ {
+			return maxI < maxJ
+		}
+		if strictI, strictJ := approvalStrictness(permitted[i].Spec), approvalStrictness(permitted[j].Spec); strictI != strictJ {
+			return strictI > strictJ
+		}
+		return permitted[i].Name < permitted[j].Name
+	})
+	return &permitted[0]
+}
+
+// DescribeRoleRef formats a role reference for messages, e.g. "ClusterRole 'admin'".
+func DescribeRoleRef(roleRef rbacv1.RoleRef) string {
+	return fmt.Sprintf("%s '%s'", roleRef.Kind, roleRef.Name)
+}
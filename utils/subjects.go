@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// MatchesPattern reports whether the value matches the glob pattern, where '*' matches any sequence
+// of characters and '?' matches a single character. All other characters, including those special
+// to regular expressions, match literally. Patterns without wildcards match exactly.
+func MatchesPattern(pattern string, value string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == value
+	}
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+	matched, err := regexp.MatchString("^"+expression+"$", value)
+	return err == nil && matched
+}
+
+// IsAllowedRequester reports whether the requester is allowed by the policy,
+// either by user name or by membership of one of the allowed groups. Policies
+// without allowed users or groups leave eligibility to their conditions.
+func IsAllowedRequester(policySpec v1.SudoPolicySpec, requester v1.UserIdentity) bool {
+	if len(policySpec.AllowedUsers) == 0 && len(policySpec.AllowedGroups) == 0 {
+		return len(policySpec.Conditions) > 0
+	}
+	for _, user := range policySpec.AllowedUsers {
+		if MatchesPattern(user.Name, requester.Username) {
+			return true
+		}
+	}
+	for _, allowedGroup := range policySpec.AllowedGroups {
+		for _, group := range requester.Groups {
+			if MatchesPattern(allowedGroup, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckRecurringSubject returns why the creator of a recurring request may not schedule grants for its subject
+// under the policy, or an empty string when it may. The creator must be allowed by the policy itself. Users may
+// only schedule grants for themselves, and a group is only granted when the policy names it in allowedGroups
+// without wildcards and the creator is a member of it. Service accounts are checked against the policy as subjects.
+func CheckRecurringSubject(policySpec v1.SudoPolicySpec, creator v1.UserIdentity, subject rbacv1.Subject) string {
+	if creator.Username == "" {
+		return "Creator of the recurring request is unknown, it must be created through the admission webhook"
+	}
+	if !IsAllowedRequester(policySpec, creator) {
+		return fmt.Sprintf("Creator '%s' not allowed by policy", creator.Username)
+	}
+	if err := CheckRequiredAttributes(policySpec, creator); err != nil {
+		return fmt.Sprintf("Creator does not satisfy the attribute requirements of the policy: %v", err)
+	}
+	switch subject.Kind {
+	case rbacv1.UserKind:
+		if subject.Name != creator.Username {
+			return fmt.Sprintf("Creator '%s' can only schedule grants for themselves, not for user '%s'", creator.Username, subject.Name)
+		}
+	case rbacv1.GroupKind:
+		if !containsString(policySpec.AllowedGroups, subject.Name) {
+			return fmt.Sprintf("Group '%s' is not explicitly allowed by the policy", subject.Name)
+		}
+		if !containsString(creator.Groups, subject.Name) {
+			return fmt.Sprintf("Creator '%s' is not a member of group '%s'", creator.Username, subject.Name)
+		}
+	}
+	return ""
+}
+
+// SubjectIdentity returns the identity a binding subject authenticates as, so that it can be checked against
+// a policy when no request was submitted by the subject itself. Groups are represented by their membership only.
+func SubjectIdentity(subject rbacv1.Subject) v1.UserIdentity {
+	switch subject.Kind {
+	case rbacv1.GroupKind:
+		return v1.UserIdentity{Groups: []string{subject.Name}}
+	case rbacv1.ServiceAccountKind:
+		return v1.UserIdentity{
+			Username: fmt.Sprintf("system:serviceaccount:%s:%s", subject.Namespace, subject.Name),
+			Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:" + subject.Namespace},
+		}
+	default:
+		return v1.UserIdentity{Username: subject.Name}
+	}
+}
+
+// ValidateAttributeRequirement checks that an attribute requirement is well formed.
+func ValidateAttributeRequirement(requirement v1.AttributeRequirement) error {
+	if requirement.Key == "" {
+		return fmt.Errorf("attribute requirement key must not be empty")
+	}
+	switch requirement.Operator {
+	case "Equals":
+		if len(requirement.Values) != 1 {
+			return fmt.Errorf("attribute requirement '%s' with operator Equals must have exactly one value", requirement.Key)
+		}
+	case "In":
+		if len(requirement.Values) == 0 {
+			return fmt.Errorf("attribute requirement '%s' with operator In must have at least one value", requirement.Key)
+		}
+	case "Exists":
+		if len(requirement.Values) != 0 {
+			return fmt.Errorf("attribute requirement '%s' with operator Exists must not have values", requirement.Key)
+		}
+	default:
+		return fmt.Errorf("attribute requirement '%s' has unsupported operator '%s'", requirement.Key, requirement.Operator)
+	}
+	return nil
+}
+
+// CheckRequiredAttributes verifies the extra attributes of the requester against the attribute
+// requirements of the policy, and describes the first requirement that is not satisfied.
+func CheckRequiredAttributes(policySpec v1.SudoPolicySpec, requester v1.UserIdentity) error {
+	for _, requirement := range policySpec.RequiredAttributes {
+		if err := ValidateAttributeRequirement(requirement); err != nil {
+			return err
+		}
+		values, exists := requester.Extra[requirement.Key]
+		switch requirement.Operator {
+		case "Exists":
+			if !exists {
+				return fmt.Errorf("attribute '%s' is required", requirement.Key)
+			}
+		case "Equals", "In":
+			if !containsAny(requirement.Values, values) {
+				if requirement.Operator == "Equals" {
+					return fmt.Errorf("attribute '%s' must equal '%s', got %v", requirement.Key, requirement.Values[0], values)
+				}
+				return fmt.Errorf("attribute '%s' must be one of %v, got %v", requirement.Key, requirement.Values, values)
+			}
+		}
+	}
+	return nil
+}
+
+// containsAny reports whether any of the values is one of the accepted values.
+func containsAny(accepted []string, values []string) bool {
+	for _, value := range values {
+		for _, candidate := range accepted {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
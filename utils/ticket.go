@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	v1 "github.com/guybal/tarbac/api/v1"
+)
+
+const (
+	// JustificationAnnotation carries the justification of a request to the resources created for it
+	JustificationAnnotation = "tarbac.io/justification"
+	// TicketAnnotation carries the ticket reference of a request to the resources created for it
+	TicketAnnotation = "tarbac.io/ticket"
+)
+
+// ValidateTextRequirement checks that the pattern of a text requirement compiles.
+func ValidateTextRequirement(field string, requirement *v1.TextRequirement) error {
+	if requirement == nil || requirement.Pattern == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(requirement.Pattern); err != nil {
+		return fmt.Errorf("invalid %s pattern '%s': %v", field, requirement.Pattern, err)
+	}
+	return nil
+}
+
+// CheckTextRequirement checks the value of a request field against a text requirement of its policy.
+// Values are only matched against the pattern when they are set, unless the field is required.
+func CheckTextRequirement(field string, value string, requirement *v1.TextRequirement) error {
+	if requirement == nil {
+		return nil
+	}
+	if strings.TrimSpace(value) == "" {
+		if requirement.Required {
+			return fmt.Errorf("a %s is required by the policy", field)
+		}
+		return nil
+	}
+	if requirement.Pattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(requirement.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid %s pattern '%s': %v", field, requirement.Pattern, err)
+	}
+	if !pattern.MatchString(value) {
+		return fmt.Errorf("%s '%s' does not match the pattern '%s' of the policy", field, value, requirement.Pattern)
+	}
+	return nil
+}
+
+// CheckReferences checks the justification and the ticket of a request against the requirements of its policy.
+func CheckReferences(policySpec v1.SudoPolicySpec, justification string, ticket string) error {
+	if err := CheckTextRequirement("justification", justification, policySpec.Justification); err != nil {
+		return err
+	}
+	return CheckTextRequirement("ticket", ticket, policySpec.Ticket)
+}
+
+// ReferenceAnnotations returns the annotations recording the justification and the ticket of a request
+// on the resources created for it, or nil when neither is set.
+func ReferenceAnnotations(justification string, ticket string) map[string]string {
+	if justification == "" && ticket == "" {
+		return nil
+	}
+	annotations := map[string]string{}
+	if justification != "" {
+		annotations[JustificationAnnotation] = justification
+	}
+	if ticket != "" {
+		annotations[TicketAnnotation] = ticket
+	}
+	return annotations
+}
+
+// DescribeReferences formats the justification and the ticket of a request for event messages,
+// e.g. " for ticket 'OPS-1234': database failover", or an empty string when neither is set.
+func DescribeReferences(justification string, ticket string) string {
+	var description string
+	if ticket != "" {
+		description = fmt.Sprintf(" for ticket '%s'", ticket)
+	}
+	if justification != "" {
+		description = fmt.Sprintf("%s: %s", description, justification)
+	}
+	return description
+}
+
+// TicketInput is the request context sent to a ticket validator.
+type TicketInput struct {
+	Ticket    string `json:"ticket"`              // Ticket reference of the request
+	Kind      string `json:"kind"`                // Kind of the request
+	Name      string `json:"name"`                // Name of the request
+	Namespace string `json:"namespace,omitempty"` // Namespace of the request, empty for cluster-scoped requests
+	Policy    string `json:"policy"`              // Name of the policy the request refers to
+	Requester string `json:"requester"`           // Username of the requester
+}
+
+// TicketResult is the answer of a ticket validator.
+type TicketResult struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TicketValidator confirms with an external HTTP service, such as a bridge to the ticketing system,
+// that the ticket of a request exists and is open. The input is posted as JSON and the answer
+// is read as {"valid": bool, "reason": string}.
+type TicketValidator struct {
+	URL        string        // Endpoint of the validator
+	Timeout    time.Duration // Timeout of a single validation
+	CacheTTL   time.Duration // How long validations are cached, 0 disables caching
+	HTTPClient *http.Client
+
+	cache ttlCache[TicketResult]
+}
+
+// NewTicketValidator creates a TicketValidator for the given endpoint.
+func NewTicketValidator(url string, timeout time.Duration, cacheTTL time.Duration) *TicketValidator {
+	return &TicketValidator{
+		URL:        url,
+		Timeout:    timeout,
+		CacheTTL:   cacheTTL,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Validate asks the validator whether the ticket is valid. Answers are cached per input, so a request
+// reconciled again with an unchanged ticket is not sent again. An error is returned when the validator
+// cannot be reached or answers with an invalid response.
+func (v *TicketValidator) Validate(ctx context.Context, input TicketInput) (TicketResult, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return TicketResult{}, err
+	}
+
+	key := cacheKey(body)
+	if result, ok := v.cache.get(key); ok {
+		return result, nil
+	}
+
+	if v.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Timeout)
+		defer cancel()
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, bytes.NewReader(body))
+	if err != nil {
+		return TicketResult{}, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return TicketResult{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return TicketResult{}, fmt.Errorf("ticket validator returned status %d", response.StatusCode)
+	}
+
+	var result TicketResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return TicketResult{}, fmt.Errorf("failed to decode ticket validation: %v", err)
+	}
+	if !result.Valid && result.Reason == "" {
+		result.Reason = "no reason given"
+	}
+
+	v.cache.set(key, result, v.CacheTTL)
+	return result, nil
+}
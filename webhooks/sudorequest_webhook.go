@@ -4,9 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	v1 "github.com/guybal/tarbac/api/v1"
 	"github.com/guybal/tarbac/utils"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	serializer "k8s.io/apimachinery/pkg/runtime/serializer"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -40,6 +45,10 @@ func (a *SudoRequestAnnotator) Handle(ctx context.Context, req admission.Request
 		return a.handleSudoRequest(ctx, req)
 	case "ClusterSudoRequest":
 		return a.handleClusterSudoRequest(ctx, req)
+	case "RecurringSudoRequest":
+		return a.handleRecurringSudoRequest(ctx, req)
+	case "ClusterRecurringSudoRequest":
+		return a.handleClusterRecurringSudoRequest(ctx, req)
 	default:
 		return admission.Denied("Ignoring unsupported resource kind")
 	}
@@ -56,21 +65,24 @@ func (a *SudoRequestAnnotator) handleSudoRequest(ctx context.Context, req admiss
 
 	fmt.Printf("Decoded SudoRequest: %+v\n", sudoRequest)
 
-	// Add annotations
-	if sudoRequest.Annotations == nil {
-		sudoRequest.Annotations = map[string]string{}
+	var oldSudoRequest v1.SudoRequest
+	if req.Operation == admissionv1.Update {
+		if err := a.Decoder.DecodeRaw(req.OldObject, &oldSudoRequest); err != nil {
+			utils.LogError(logger, err, fmt.Sprintf("Decode error for previous SudoRequest: %v\n", err))
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode previous SudoRequest: %v", err))
+		}
 	}
 
-	if sudoRequest.Annotations["tarbac.io/requester"] == "" {
-		sudoRequest.Annotations["tarbac.io/requester"] = req.UserInfo.Username
-	} else if sudoRequest.Annotations["tarbac.io/requester"] != "" && sudoRequest.Annotations["tarbac.io/requester"] != req.UserInfo.Username {
-		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("requesting user does not match the original requester"))
+	// A SudoRequest is granted in its own namespace
+	if req.Operation == admissionv1.Create && len(sudoRequest.Spec.Namespaces) > 0 {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("namespaces can only be requested by ClusterSudoRequests"))
 	}
 
-	if sudoRequest.Annotations["tarbac.io/requester-metadata"] == "" {
-		sudoRequest.Annotations["tarbac.io/requester-metadata"] = fmt.Sprintf("UID=%s, Groups=%v", req.UserInfo.UID, req.UserInfo.Groups)
-	} else if sudoRequest.Annotations["tarbac.io/requester-metadata"] != "" && sudoRequest.Annotations["tarbac.io/requester-metadata"] != fmt.Sprintf("UID=%s, Groups=%v", req.UserInfo.UID, req.UserInfo.Groups) {
-		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("requesting user does not match the original requester"))
+	if err := a.annotateRequest(req, &sudoRequest.ObjectMeta, &sudoRequest.Spec, &oldSudoRequest.ObjectMeta, &oldSudoRequest.Spec); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if req.Operation == admissionv1.Update {
+		keepPendingReviewLabel(&sudoRequest.ObjectMeta, &oldSudoRequest.ObjectMeta, oldSudoRequest.Status.ReviewState)
 	}
 
 	utils.LogInfo(logger, fmt.Sprintf("Updated SudoRequest with annotations: %+v\n", sudoRequest.Annotations))
@@ -89,21 +101,19 @@ func (a *SudoRequestAnnotator) handleClusterSudoRequest(ctx context.Context, req
 
 	utils.LogInfo(logger, fmt.Sprintf("Decoded ClusterSudoRequest: %+v\n", clusterSudoRequest))
 
-	// Add annotations
-	if clusterSudoRequest.Annotations == nil {
-		clusterSudoRequest.Annotations = map[string]string{}
+	var oldClusterSudoRequest v1.ClusterSudoRequest
+	if req.Operation == admissionv1.Update {
+		if err := a.Decoder.DecodeRaw(req.OldObject, &oldClusterSudoRequest); err != nil {
+			utils.LogError(logger, err, fmt.Sprintf("Decode error for previous ClusterSudoRequest: %v\n", err))
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode previous ClusterSudoRequest: %v", err))
+		}
 	}
 
-	if clusterSudoRequest.Annotations["tarbac.io/requester"] == "" {
-		clusterSudoRequest.Annotations["tarbac.io/requester"] = req.UserInfo.Username
-	} else if clusterSudoRequest.Annotations["tarbac.io/requester"] != "" && clusterSudoRequest.Annotations["tarbac.io/requester"] != req.UserInfo.Username {
-		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("requesting user does not match the original requester"))
+	if err := a.annotateRequest(req, &clusterSudoRequest.ObjectMeta, &clusterSudoRequest.Spec, &oldClusterSudoRequest.ObjectMeta, &oldClusterSudoRequest.Spec); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
 	}
-
-	if clusterSudoRequest.Annotations["tarbac.io/requester-metadata"] == "" {
-		clusterSudoRequest.Annotations["tarbac.io/requester-metadata"] = fmt.Sprintf("UID=%s, Groups=%v", req.UserInfo.UID, req.UserInfo.Groups)
-	} else if clusterSudoRequest.Annotations["tarbac.io/requester-metadata"] != "" && clusterSudoRequest.Annotations["tarbac.io/requester-metadata"] != fmt.Sprintf("UID=%s, Groups=%v", req.UserInfo.UID, req.UserInfo.Groups) {
-		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("requesting user does not match the original requester"))
+	if req.Operation == admissionv1.Update {
+		keepPendingReviewLabel(&clusterSudoRequest.ObjectMeta, &oldClusterSudoRequest.ObjectMeta, oldClusterSudoRequest.Status.ReviewState)
 	}
 
 	utils.LogInfo(logger, fmt.Sprintf("Updated ClusterSudoRequest with annotations: %+v\n", clusterSudoRequest.Annotations))
@@ -111,6 +121,242 @@ func (a *SudoRequestAnnotator) handleClusterSudoRequest(ctx context.Context, req
 	return a.encodeAndPatchResponse(ctx, req, &clusterSudoRequest)
 }
 
+func (a *SudoRequestAnnotator) handleRecurringSudoRequest(ctx context.Context, req admission.Request) admission.Response {
+
+	logger := log.FromContext(ctx)
+	var recurringRequest v1.RecurringSudoRequest
+	if err := a.Decoder.Decode(req, &recurringRequest); err != nil {
+		utils.LogError(logger, err, fmt.Sprintf("Decode error for RecurringSudoRequest: %v\n", err))
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode RecurringSudoRequest: %v", err))
+	}
+
+	var oldRecurringRequest v1.RecurringSudoRequest
+	if req.Operation == admissionv1.Update {
+		if err := a.Decoder.DecodeRaw(req.OldObject, &oldRecurringRequest); err != nil {
+			utils.LogError(logger, err, fmt.Sprintf("Decode error for previous RecurringSudoRequest: %v\n", err))
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode previous RecurringSudoRequest: %v", err))
+		}
+	}
+
+	recordCreator(req, &recurringRequest.Spec, &oldRecurringRequest.Spec)
+
+	return a.encodeAndPatchResponse(ctx, req, &recurringRequest)
+}
+
+func (a *SudoRequestAnnotator) handleClusterRecurringSudoRequest(ctx context.Context, req admission.Request) admission.Response {
+
+	logger := log.FromContext(ctx)
+	var recurringRequest v1.ClusterRecurringSudoRequest
+	if err := a.Decoder.Decode(req, &recurringRequest); err != nil {
+		utils.LogError(logger, err, fmt.Sprintf("Decode error for ClusterRecurringSudoRequest: %v\n", err))
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode ClusterRecurringSudoRequest: %v", err))
+	}
+
+	var oldRecurringRequest v1.ClusterRecurringSudoRequest
+	if req.Operation == admissionv1.Update {
+		if err := a.Decoder.DecodeRaw(req.OldObject, &oldRecurringRequest); err != nil {
+			utils.LogError(logger, err, fmt.Sprintf("Decode error for previous ClusterRecurringSudoRequest: %v\n", err))
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode previous ClusterRecurringSudoRequest: %v", err))
+		}
+	}
+
+	recordCreator(req, &recurringRequest.Spec, &oldRecurringRequest.Spec)
+
+	return a.encodeAndPatchResponse(ctx, req, &recurringRequest)
+}
+
+// recordCreator stamps the authenticated identity of the creator on a recurring request. User supplied values
+// are discarded and, on update, the original creator is kept, as the controller checks it against the policy
+// on every occurrence.
+func recordCreator(req admission.Request, spec *v1.RecurringSudoRequestSpec, oldSpec *v1.RecurringSudoRequestSpec) {
+	spec.Creator = userIdentity(req.UserInfo)
+	if req.Operation == admissionv1.Update {
+		spec.Creator = oldSpec.Creator
+	}
+}
+
+// keepPendingReviewLabel keeps a break-glass request labelled as awaiting review until the controller has recorded
+// its review, so that the requester cannot hide it from the listing of requests awaiting review.
+func keepPendingReviewLabel(meta *metav1.ObjectMeta, oldMeta *metav1.ObjectMeta, reviewState string) {
+	if reviewState == "Reviewed" || (reviewState != "AwaitingReview" && oldMeta.Labels[utils.PendingReviewLabel] != "true") {
+		return
+	}
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[utils.PendingReviewLabel] = "true"
+}
+
+// annotateRequest stamps the requester identity on a request and records approvals, extensions, revocations
+// and reviews made through the "tarbac.io/approve", "tarbac.io/extend", "tarbac.io/revoke" and "tarbac.io/review"
+// annotations. On update, the previously stamped values are carried over so that they can only ever be set by
+// this webhook, and the policy or role requested, the namespaces, the break-glass declaration, justification
+// and ticket of the request cannot be changed.
+func (a *SudoRequestAnnotator) annotateRequest(req admission.Request, meta *metav1.ObjectMeta, spec *v1.SudoRequestSpec, oldMeta *metav1.ObjectMeta, oldSpec *v1.SudoRequestSpec) error {
+	// Add annotations
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+
+	// The requester is captured from the authenticated user on creation, user supplied values are discarded
+	spec.Requester = userIdentity(req.UserInfo)
+	if req.Operation == admissionv1.Update {
+		// The original requester is kept, the user performing the update may be an approver or the controller
+		spec.Requester = oldSpec.Requester
+		if spec.Requester.Username == "" {
+			// Requests created before the identity was recorded in the spec only carry the annotation
+			spec.Requester.Username = oldMeta.Annotations["tarbac.io/requester"]
+		}
+	}
+	requester := spec.Requester.Username
+
+	if meta.Annotations["tarbac.io/requester"] != "" && meta.Annotations["tarbac.io/requester"] != requester {
+		return fmt.Errorf("requesting user does not match the original requester")
+	}
+	meta.Annotations["tarbac.io/requester"] = requester
+
+	// Approvals are only ever appended by the webhook on behalf of the authenticated user
+	spec.Approvals = nil
+	if req.Operation == admissionv1.Update {
+		spec.Approvals = oldSpec.Approvals
+		if _, ok := meta.Annotations["tarbac.io/approve"]; ok {
+			if req.UserInfo.Username == requester {
+				return fmt.Errorf("requesters cannot approve their own request")
+			}
+			spec.Approvals = append(spec.Approvals, v1.Approval{
+				Approver:  userIdentity(req.UserInfo),
+				Timestamp: metav1.Now(),
+			})
+		}
+	}
+	delete(meta.Annotations, "tarbac.io/approve")
+
+	// Extensions are only ever appended by the webhook on behalf of the requester
+	spec.Extensions = nil
+	if req.Operation == admissionv1.Update {
+		spec.Extensions = oldSpec.Extensions
+		if extension, ok := meta.Annotations["tarbac.io/extend"]; ok {
+			if req.UserInfo.Username != requester {
+				return fmt.Errorf("only the requester can extend a request")
+			}
+			if duration, err := time.ParseDuration(extension); err != nil || duration <= 0 {
+				return fmt.Errorf("invalid extension duration '%s'", extension)
+			}
+			spec.Extensions = append(spec.Extensions, v1.ExtensionRequest{
+				Duration:  extension,
+				Timestamp: metav1.Now(),
+			})
+		}
+	}
+	delete(meta.Annotations, "tarbac.io/extend")
+
+	// A revocation is recorded once, by the requester or by an admin giving a reason
+	spec.Revocation = nil
+	if req.Operation == admissionv1.Update {
+		spec.Revocation = oldSpec.Revocation
+		if reason, ok := meta.Annotations["tarbac.io/revoke"]; ok && spec.Revocation == nil {
+			if req.UserInfo.Username != requester && reason == "" {
+				return fmt.Errorf("a reason is required to revoke the request of another user")
+			}
+			spec.Revocation = &v1.Revocation{
+				RevokedBy: userIdentity(req.UserInfo),
+				Reason:    reason,
+				Timestamp: metav1.Now(),
+			}
+		}
+	}
+	delete(meta.Annotations, "tarbac.io/revoke")
+
+	// The break-glass declaration, justification and ticket are set on creation, break-glass requests must be justified
+	if req.Operation == admissionv1.Update {
+		spec.BreakGlass = oldSpec.BreakGlass
+		spec.Justification = oldSpec.Justification
+		spec.Ticket = oldSpec.Ticket
+	}
+	if spec.BreakGlass && strings.TrimSpace(spec.Justification) == "" {
+		return fmt.Errorf("a justification is required for break-glass requests")
+	}
+
+	// The duration and start time are set on creation, so that approvals always apply to the grant that was approved
+	if req.Operation == admissionv1.Update {
+		spec.Duration = oldSpec.Duration
+		spec.StartTime = oldSpec.StartTime
+	}
+
+	// Requests name a policy, bundle several policies or ask for a role, whose policy is selected by the controller,
+	// set on creation together with the subset of its permissions requested
+	if req.Operation == admissionv1.Update {
+		spec.Policy = oldSpec.Policy
+		spec.Policies = oldSpec.Policies
+		spec.RoleRef = oldSpec.RoleRef
+		spec.Namespaces = oldSpec.Namespaces
+		spec.Rules = oldSpec.Rules
+		spec.ResourceNames = oldSpec.ResourceNames
+	} else {
+		requested := 0
+		for _, set := range []bool{spec.Policy != "", len(spec.Policies) > 0, spec.RoleRef != nil} {
+			if set {
+				requested++
+			}
+		}
+		if requested == 0 {
+			return fmt.Errorf("either a policy, policies or a roleRef must be requested")
+		}
+		if requested > 1 {
+			return fmt.Errorf("only one of a policy, policies and a roleRef can be requested")
+		}
+		if err := utils.ValidateBundle(spec.Policies); err != nil {
+			return fmt.Errorf("invalid policies: %v", err)
+		}
+		// Each policy of a bundle grants the namespaces it allows
+		if len(spec.Policies) > 0 && len(spec.Namespaces) > 0 {
+			return fmt.Errorf("namespaces cannot be requested with policies")
+		}
+		if len(spec.Policies) > 0 && (len(spec.Rules) > 0 || len(spec.ResourceNames) > 0) {
+			return fmt.Errorf("rules and resource names cannot be requested with policies")
+		}
+	}
+
+	// Reviews of break-glass requests are only ever appended by the webhook on behalf of the authenticated user
+	spec.Reviews = nil
+	if req.Operation == admissionv1.Update {
+		spec.Reviews = oldSpec.Reviews
+		if comment, ok := meta.Annotations["tarbac.io/review"]; ok {
+			if !spec.BreakGlass {
+				return fmt.Errorf("only break-glass requests can be reviewed")
+			}
+			if req.UserInfo.Username == requester {
+				return fmt.Errorf("requesters cannot review their own request")
+			}
+			spec.Reviews = append(spec.Reviews, v1.Review{
+				Reviewer:  userIdentity(req.UserInfo),
+				Comment:   comment,
+				Timestamp: metav1.Now(),
+			})
+		}
+	}
+	delete(meta.Annotations, "tarbac.io/review")
+
+	return nil
+}
+
+// userIdentity converts the admission request user info into a UserIdentity
+func userIdentity(userInfo authenticationv1.UserInfo) v1.UserIdentity {
+	var extra map[string][]string
+	if userInfo.Extra != nil {
+		extra = make(map[string][]string, len(userInfo.Extra))
+		for key, value := range userInfo.Extra {
+			extra[key] = value
+		}
+	}
+	return v1.UserIdentity{
+		Username: userInfo.Username,
+		UID:      userInfo.UID,
+		Groups:   userInfo.Groups,
+		Extra:    extra,
+	}
+}
+
 func (a *SudoRequestAnnotator) encodeAndPatchResponse(ctx context.Context, req admission.Request, obj runtime.Object) admission.Response {
 	logger := log.FromContext(ctx)
 	// Encode the mutated object using the manager's Scheme